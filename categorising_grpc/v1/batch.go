@@ -0,0 +1,166 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"sync"
+)
+
+const (
+	defaultConcurrency = 8
+	maxBatchSize       = 1000
+)
+
+// The outcome of categorising one text
+type result struct {
+	resp *pb.GetCategoryResponse
+	err  error
+}
+
+// Waits for a free slot for calling the engine
+func (s server) acquire(ctx context.Context) error {
+	if s.slots == nil {
+		return nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Frees a slot taken by acquire
+func (s server) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// Categorises a text once a slot is free and rates its priority
+func (s server) classify(ctx context.Context, r *pb.GetCategoryRequest) (*pb.GetCategoryResponse, error) {
+	if err := s.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.release()
+	resp, err := s.engine.classify(ctx, r.GetText(), r.GetLanguageCode())
+	if err != nil {
+		return nil, err
+	}
+	return withPriority(resp, r.GetText(), r.GetCustomerTier()), nil
+}
+
+// Categorises the texts concurrently, keeping the responses in the order of
+// the requests. Stops at the first failure.
+func (s server) classifyAll(ctx context.Context, rs []*pb.GetCategoryRequest) ([]*pb.GetCategoryResponse, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]result, len(rs))
+	var wg sync.WaitGroup
+	for i, r := range rs {
+		wg.Add(1)
+		go func(i int, r *pb.GetCategoryRequest) {
+			defer wg.Done()
+			resp, err := s.classify(ctx, r)
+			if err != nil {
+				cancel()
+			}
+			results[i] = result{resp, err}
+		}(i, r)
+	}
+	wg.Wait()
+
+	resps := make([]*pb.GetCategoryResponse, len(rs))
+	for i, res := range results {
+		if res.err != nil {
+			return nil, res.err
+		}
+		resps[i] = res.resp
+	}
+	return resps, nil
+}
+
+func (s server) BatchGetCategory(ctx context.Context, r *pb.BatchGetCategoryRequest) (*pb.BatchGetCategoryResponse, error) {
+	if len(r.GetRequests()) > maxBatchSize {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %v texts per batch, got %v", maxBatchSize, len(r.GetRequests()))
+	}
+
+	resps, err := s.classifyAll(ctx, r.GetRequests())
+	if err != nil {
+		log.Printf("WARN: failed to categorise batch: %v", err)
+		return nil, err
+	}
+	return &pb.BatchGetCategoryResponse{Responses: resps}, nil
+}
+
+// Reads requests from the stream, categorising each in the background. The
+// outcomes are queued in the order of the requests; the queue closes when the
+// client is done sending or the stream fails.
+func (s server) receive(ctx context.Context, stream pb.Categorising_StreamGetCategoryServer, pending chan<- chan result) error {
+	defer close(pending)
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		res := make(chan result, 1)
+		select {
+		case pending <- res:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		go func() {
+			resp, err := s.classify(ctx, r)
+			res <- result{resp, err}
+		}()
+	}
+}
+
+func (s server) StreamGetCategory(stream pb.Categorising_StreamGetCategoryServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	// bounds how far categorising may run ahead of sending
+	pending := make(chan chan result, cap(s.slots)+1)
+	received := make(chan error, 1)
+	go func() {
+		received <- s.receive(ctx, stream, pending)
+	}()
+
+	for res := range pending {
+		out := <-res
+		if out.err != nil {
+			log.Printf("WARN: failed to categorise text: %v", out.err)
+			return out.err
+		}
+		if err := stream.Send(out.resp); err != nil {
+			return err
+		}
+	}
+	return <-received
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A classifier that answers with the category numbered by the text, taking
+// longer for lower numbers so that answers arrive out of order
+type slowClassifier struct {
+	mu      sync.Mutex
+	running int
+	// the most calls that ran at once
+	peak int
+}
+
+func (c *slowClassifier) classify(_ context.Context, text, _ string) (*pb.GetCategoryResponse, error) {
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		return nil, errors.New("not a number")
+	}
+
+	c.mu.Lock()
+	c.running += 1
+	if c.running > c.peak {
+		c.peak = c.running
+	}
+	c.mu.Unlock()
+
+	time.Sleep(time.Duration(5-n%5) * time.Millisecond)
+
+	c.mu.Lock()
+	c.running -= 1
+	c.mu.Unlock()
+	return &pb.GetCategoryResponse{Category: messagepb.MessageCategory(n % 5)}, nil
+}
+
+func (c *slowClassifier) close() error {
+	return nil
+}
+
+func newRequests(texts ...string) []*pb.GetCategoryRequest {
+	var rs []*pb.GetCategoryRequest
+	for _, t := range texts {
+		rs = append(rs, &pb.GetCategoryRequest{Text: t})
+	}
+	return rs
+}
+
+func TestServer_BatchGetCategory(t *testing.T) {
+	var texts []string
+	for i := 0; i < 20; i += 1 {
+		texts = append(texts, strconv.Itoa(i))
+	}
+	engine := &slowClassifier{}
+	s := newServer(engine, nil, 3)
+
+	resp, err := s.BatchGetCategory(context.Background(), &pb.BatchGetCategoryRequest{Requests: newRequests(texts...)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.GetResponses()) != len(texts) {
+		t.Fatalf("expected %v responses, got %v", len(texts), len(resp.GetResponses()))
+	}
+	for i, r := range resp.GetResponses() {
+		if expected := messagepb.MessageCategory(i % 5); r.GetCategory() != expected {
+			t.Errorf("response %v: expected %v, got %v", i, expected, r.GetCategory())
+		}
+	}
+	if engine.peak > 3 {
+		t.Errorf("expected at most 3 concurrent calls, got %v", engine.peak)
+	}
+
+	if _, err := s.BatchGetCategory(context.Background(), &pb.BatchGetCategoryRequest{Requests: newRequests("1", "two", "3")}); err == nil {
+		t.Errorf("expected error for text that cannot be categorised")
+	}
+
+	tooMany := &pb.BatchGetCategoryRequest{Requests: make([]*pb.GetCategoryRequest, maxBatchSize+1)}
+	if _, err := s.BatchGetCategory(context.Background(), tooMany); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v, got %v", codes.InvalidArgument, err)
+	}
+}
+
+// A server stream that reads requests from a list and collects responses
+type fakeStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	rs    []*pb.GetCategoryRequest
+	sent  []*pb.GetCategoryResponse
+	recvd int
+}
+
+func (f *fakeStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeStream) Recv() (*pb.GetCategoryRequest, error) {
+	if f.recvd == len(f.rs) {
+		return nil, io.EOF
+	}
+	f.recvd += 1
+	return f.rs[f.recvd-1], nil
+}
+
+func (f *fakeStream) Send(r *pb.GetCategoryResponse) error {
+	f.sent = append(f.sent, r)
+	return nil
+}
+
+func TestServer_StreamGetCategory(t *testing.T) {
+	cases := []struct {
+		texts []string
+		sent  int
+		err   bool
+	}{
+		{[]string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, 10, false},
+		{nil, 0, false},
+		{[]string{"1", "2", "three", "4"}, 2, true},
+	}
+	for i, c := range cases {
+		engine := &slowClassifier{}
+		stream := &fakeStream{ctx: context.Background(), rs: newRequests(c.texts...)}
+
+		err := newServer(engine, nil, 2).StreamGetCategory(stream)
+		if (err != nil) != c.err {
+			t.Errorf("case %v: expected error %v, got %v", i, c.err, err)
+		}
+		if len(stream.sent) != c.sent {
+			t.Errorf("case %v: expected %v responses, got %v", i, c.sent, len(stream.sent))
+		}
+		for j, r := range stream.sent {
+			n, _ := strconv.Atoi(c.texts[j])
+			if expected := messagepb.MessageCategory(n % 5); r.GetCategory() != expected {
+				t.Errorf("case %v: response %v: expected %v, got %v", i, j, expected, r.GetCategory())
+			}
+		}
+		if engine.peak > 2 {
+			t.Errorf("case %v: expected at most 2 concurrent calls, got %v", i, engine.peak)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package bayes implements a multinomial naive Bayes text classifier that can
+// be trained offline and stored as a JSON file.
+package bayes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Model holds the token counts per label.
+type Model struct {
+	// Identifies the training run that produced the model
+	Version string            `json:"version,omitempty"`
+	Classes map[string]*Class `json:"classes"`
+}
+
+// Class holds the training statistics of a single label.
+type Class struct {
+	Documents int            `json:"documents"`
+	Tokens    int            `json:"tokens"`
+	Counts    map[string]int `json:"counts"`
+}
+
+// New creates an empty model.
+func New() *Model {
+	return &Model{Classes: make(map[string]*Class)}
+}
+
+// Load reads a model from a JSON file.
+func Load(path string) (*Model, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := New()
+	if err := json.Unmarshal(bs, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Save writes the model to a JSON file.
+func (m *Model) Save(path string) error {
+	bs, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bs, 0644)
+}
+
+// Tokenize splits a text into lower case words.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// Add trains the model with a labelled text.
+func (m *Model) Add(label, text string) {
+	c, ok := m.Classes[label]
+	if !ok {
+		c = &Class{Counts: make(map[string]int)}
+		m.Classes[label] = c
+	}
+	c.Documents += 1
+	for _, t := range Tokenize(text) {
+		c.Counts[t] += 1
+		c.Tokens += 1
+	}
+}
+
+// Labels returns the known labels in alphabetical order.
+func (m *Model) Labels() []string {
+	var ls []string
+	for l := range m.Classes {
+		ls = append(ls, l)
+	}
+	sort.Strings(ls)
+	return ls
+}
+
+func (m *Model) vocabulary() int {
+	vs := make(map[string]bool)
+	for _, c := range m.Classes {
+		for t := range c.Counts {
+			vs[t] = true
+		}
+	}
+	return len(vs)
+}
+
+// Scores returns the posterior probability of every label for the text.
+// Token likelihoods use Laplace smoothing; unknown tokens are ignored.
+func (m *Model) Scores(text string) map[string]float64 {
+	docs := 0
+	for _, c := range m.Classes {
+		docs += c.Documents
+	}
+	if docs == 0 {
+		return nil
+	}
+
+	v := float64(m.vocabulary())
+	ts := Tokenize(text)
+	logs := make(map[string]float64, len(m.Classes))
+	max := math.Inf(-1)
+	for l, c := range m.Classes {
+		p := math.Log(float64(c.Documents) / float64(docs))
+		for _, t := range ts {
+			if !m.known(t) {
+				continue
+			}
+			p += math.Log((float64(c.Counts[t]) + 1) / (float64(c.Tokens) + v))
+		}
+		logs[l] = p
+		if p > max {
+			max = p
+		}
+	}
+
+	// normalise in log space to avoid underflow on long texts
+	sum := 0.
+	for l, p := range logs {
+		logs[l] = math.Exp(p - max)
+		sum += logs[l]
+	}
+	for l := range logs {
+		logs[l] /= sum
+	}
+	return logs
+}
+
+func (m *Model) known(t string) bool {
+	for _, c := range m.Classes {
+		if c.Counts[t] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify returns the most probable label and its probability. Ties are
+// broken alphabetically.
+func (m *Model) Classify(text string) (string, float64) {
+	scores := m.Scores(text)
+	best, p := "", -1.
+	for _, l := range m.Labels() {
+		if scores[l] > p {
+			best, p = l, scores[l]
+		}
+	}
+	return best, p
+}
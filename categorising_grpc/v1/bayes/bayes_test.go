@@ -0,0 +1,94 @@
+package bayes
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func trained() *Model {
+	m := New()
+	m.Add("COMPLAINT", "It arrived broken, I want a refund")
+	m.Add("COMPLAINT", "Terrible knob, broken after one day")
+	m.Add("QUESTION", "How do I install this knob?")
+	m.Add("QUESTION", "Can I return this knob?")
+	m.Add("FEEDBACK", "Great knob, love it")
+	m.Add("FEEDBACK", "Awesome service, thanks")
+	return m
+}
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected []string
+	}{
+		{"", []string{}},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"Ça je n'aime pas.", []string{"ça", "je", "n'aime", "pas"}},
+		{"order 123-abc", []string{"order", "123", "abc"}},
+	}
+	for i, c := range cases {
+		if actual := Tokenize(c.text); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestModel_Classify(t *testing.T) {
+	m := trained()
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{"my knob is broken", "COMPLAINT"},
+		{"how do I return it", "QUESTION"},
+		{"love it, thanks", "FEEDBACK"},
+	}
+	for i, c := range cases {
+		if actual, _ := m.Classify(c.text); actual != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestModel_Scores(t *testing.T) {
+	if s := New().Scores("anything"); s != nil {
+		t.Errorf("expected no scores for empty model, got %v", s)
+	}
+
+	sum := 0.
+	for _, p := range trained().Scores("broken knob, how do I get a refund?") {
+		sum += p
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("expected probabilities to sum to 1, got %v", sum)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bayes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "model.json")
+	expected := trained()
+	if err := expected.Save(path); err != nil {
+		t.Fatalf("unexpected error saving: %v", err)
+	}
+	actual, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheSize = 10000
+	defaultCacheTTL  = time.Hour
+)
+
+// Implemented by classifiers whose answers depend on models that can change
+// while running
+type versioned interface {
+	version() string
+}
+
+// Provides the version of the models of a classifier; empty when it has none.
+func versionOf(c classifier) string {
+	if v, ok := c.(versioned); ok {
+		return v.version()
+	}
+	return ""
+}
+
+type cacheEntry struct {
+	key     string
+	resp    *pb.GetCategoryResponse
+	expires time.Time
+}
+
+// Remembers the answers of an engine for identical texts. The least recently
+// used answer makes way when the cache is full and answers expire after the
+// time to live. The whole cache is dropped when the models of the engine
+// change; thresholds are compiled in, so a new build starts afresh. Cached
+// responses are shared and must not be modified.
+type cachingClassifier struct {
+	engine classifier
+	size   int
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu sync.Mutex
+	// the model version the cached answers were given under
+	current string
+	entries map[string]*list.Element
+	// most recently used first
+	order  *list.List
+	hits   int64
+	misses int64
+}
+
+// Creates a cache of at most size answers of the engine, each kept for the
+// time to live.
+func newCachingClassifier(engine classifier, size int, ttl time.Duration) *cachingClassifier {
+	return &cachingClassifier{
+		engine:  engine,
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		current: versionOf(engine),
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Derives the cache key from the content of a message
+func cacheKey(text, lang string) string {
+	h := sha256.Sum256([]byte(lang + "\x00" + text))
+	return hex.EncodeToString(h[:])
+}
+
+// Finds a live answer, counting the hit or miss
+func (c *cachingClassifier) lookup(key, version string) (*pb.GetCategoryResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version != c.current {
+		c.current = version
+		c.entries = make(map[string]*list.Element)
+		c.order.Init()
+	}
+
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if c.now().Before(e.expires) {
+			c.order.MoveToFront(el)
+			c.hits += 1
+			return e.resp, true
+		}
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+	c.misses += 1
+	return nil, false
+}
+
+// Remembers an answer given under a model version, evicting the least
+// recently used ones beyond the size of the cache
+func (c *cachingClassifier) store(key, version string, resp *pb.GetCategoryResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version != c.current {
+		return
+	}
+	e := &cacheEntry{key: key, resp: resp, expires: c.now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+	} else {
+		c.entries[key] = c.order.PushFront(e)
+	}
+	for c.order.Len() > c.size {
+		el := c.order.Back()
+		c.order.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *cachingClassifier) classify(ctx context.Context, text, lang string) (*pb.GetCategoryResponse, error) {
+	key, version := cacheKey(text, lang), versionOf(c.engine)
+	if resp, ok := c.lookup(key, version); ok {
+		return resp, nil
+	}
+
+	resp, err := c.engine.classify(ctx, text, lang)
+	if err != nil {
+		return nil, err
+	}
+	c.store(key, version, resp)
+	return resp, nil
+}
+
+// Provides the number of hits, misses and answers in the cache
+func (c *cachingClassifier) stats() (int64, int64, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.order.Len()
+}
+
+func (c *cachingClassifier) version() string {
+	return versionOf(c.engine)
+}
+
+func (c *cachingClassifier) close() error {
+	return c.engine.close()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+// A classifier that counts its calls and has a settable model version
+type countingClassifier struct {
+	calls int
+	v     string
+}
+
+func (c *countingClassifier) classify(_ context.Context, text, _ string) (*pb.GetCategoryResponse, error) {
+	c.calls += 1
+	if text == "fail" {
+		return nil, errors.New("boom")
+	}
+	return &pb.GetCategoryResponse{Category: messagepb.MessageCategory_FEEDBACK}, nil
+}
+
+func (c *countingClassifier) version() string {
+	return c.v
+}
+
+func (c *countingClassifier) close() error {
+	return nil
+}
+
+func TestCachingClassifier_classify(t *testing.T) {
+	engine := &countingClassifier{v: "1"}
+	cache := newCachingClassifier(engine, 2, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cases := []struct {
+		text  string
+		lang  string
+		calls int
+	}{
+		{"hi", "", 1},
+		{"hi", "", 1},
+		{"hi", "nl", 2},
+		{"bye", "", 3},
+		{"hi", "nl", 3},
+		// evicts "hi" without language, the least recently used
+		{"hi", "", 4},
+		{"hi", "nl", 4},
+		{"fail", "", 5},
+		{"fail", "", 6},
+	}
+	for i, c := range cases {
+		_, _ = cache.classify(context.Background(), c.text, c.lang)
+		if engine.calls != c.calls {
+			t.Errorf("case %v: expected %v engine calls, got %v", i, c.calls, engine.calls)
+		}
+	}
+	if hits, misses, n := cache.stats(); hits != 3 || misses != 6 || n != 2 {
+		t.Errorf("expected 3 hits, 6 misses and 2 entries, got %v, %v and %v", hits, misses, n)
+	}
+
+	now = now.Add(time.Minute)
+	if _, _ = cache.classify(context.Background(), "hi", "nl"); engine.calls != 7 {
+		t.Errorf("expected expired answer to be renewed, got %v engine calls", engine.calls)
+	}
+
+	engine.v = "2"
+	if _, _ = cache.classify(context.Background(), "hi", "nl"); engine.calls != 8 {
+		t.Errorf("expected new model version to invalidate the cache, got %v engine calls", engine.calls)
+	}
+	if _, _, n := cache.stats(); n != 1 {
+		t.Errorf("expected only the new answer cached, got %v", n)
+	}
+}
+
+func TestServer_GetStats(t *testing.T) {
+	engine := &countingClassifier{v: "1"}
+	s := newServer(newCachingClassifier(engine, 10, time.Minute), nil, 1)
+	for _, text := range []string{"a", "b", "a"} {
+		if _, err := s.GetCategory(context.Background(), &pb.GetCategoryRequest{Text: text}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	resp, err := s.GetStats(context.Background(), &pb.GetStatsRequest{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.GetCacheHits() != 1 || resp.GetCacheMisses() != 2 || resp.GetCacheEntries() != 2 || resp.GetModelVersion() != "1" {
+		t.Errorf("unexpected stats %v", resp)
+	}
+
+	resp, _ = newServer(engine, nil, 1).GetStats(context.Background(), &pb.GetStatsRequest{})
+	if resp.GetCacheHits()+resp.GetCacheMisses() != 0 {
+		t.Errorf("expected no cache stats without cache, got %v", resp)
+	}
+}
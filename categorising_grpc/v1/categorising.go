@@ -18,63 +18,73 @@
 package main
 
 import (
-	"cloud.google.com/go/language/apiv1"
 	"flag"
 	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
-	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/measure"
 	"golang.org/x/net/context"
-	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 const (
-	defaultPort       = "8080"
-	questionThreshold = 0
-	feedbackThreshold = .6
+	defaultPort           = "8080"
+	defaultReloadInterval = 30 * time.Second
 )
 
 type server struct {
+	engine    classifier
+	extractor *extractor
+	// limits the concurrent calls to the engine; unlimited when nil
+	slots chan struct{}
 }
 
-func (s *server) GetCategory(ctx context.Context, r *pb.GetCategoryRequest) (*pb.GetCategoryResponse, error) {
-	client, err := language.NewClient(ctx)
-	if err != nil {
-		log.Printf("error: failed to create nlp client: %v", err)
-		return nil, err
-	}
+// Creates a server that calls the engine at most concurrency times at once
+func newServer(engine classifier, ex *extractor, concurrency int) server {
+	return server{engine: engine, extractor: ex, slots: make(chan struct{}, concurrency)}
+}
 
-	sentiment, err := client.AnalyzeSentiment(ctx, &languagepb.AnalyzeSentimentRequest{
-		Document: &languagepb.Document{
-			Source: &languagepb.Document_Content{Content: r.Text},
-			Type:   languagepb.Document_PLAIN_TEXT,
-		},
-		EncodingType: languagepb.EncodingType_UTF8,
-	})
+func (s server) GetCategory(ctx context.Context, r *pb.GetCategoryRequest) (*pb.GetCategoryResponse, error) {
+	resp, err := s.classify(ctx, r)
 	if err != nil {
-		log.Printf("warning: failed to analyze text: %v", err)
+		log.Printf("WARN: failed to categorise text: %v", err)
 		return nil, err
 	}
-	score := sentiment.DocumentSentiment.Score
-
-	log.Printf("info: message was scored with %v", score)
+	return resp, nil
+}
 
-	resp := &pb.GetCategoryResponse{}
-	if score < questionThreshold {
-		resp.Category = messagepb.MessageCategory_COMPLAINT
-	} else if score < feedbackThreshold {
-		resp.Category = messagepb.MessageCategory_QUESTION
-	} else {
-		resp.Category = messagepb.MessageCategory_FEEDBACK
+func (s server) GetStats(context.Context, *pb.GetStatsRequest) (*pb.GetStatsResponse, error) {
+	resp := &pb.GetStatsResponse{ModelVersion: versionOf(s.engine)}
+	if c, ok := s.engine.(*cachingClassifier); ok {
+		hits, misses, n := c.stats()
+		resp.CacheHits, resp.CacheMisses, resp.CacheEntries = hits, misses, int32(n)
 	}
-
 	return resp, nil
 }
 
 func main() {
 	var port = flag.String("port", defaultPort, "port to listen on")
+	var engine = flag.String("engine", sentimentEngine, "categoriser engine (sentiment or local)")
+	var languageEndpoint = flag.String("language-endpoint", "", "address of a stand-in for the Natural Language API, used without TLS (optional)")
+	var rulesFile = flag.String("rules", "", "rules file for the local engine (optional)")
+	var modelFile = flag.String("model", "", "naive Bayes model file for the local engine (optional)")
+	var languageModels = flag.String("language-models", "", "language specific models for the local engine, like fr=model_fr.json,nl=model_nl.json (optional)")
+	var reloadInterval = flag.Duration("model-reload-interval", defaultReloadInterval, "interval for checking the model file for changes")
+	var spamPhrasesFile = flag.String("spam-phrases", "", "file with known spam phrases, one per line (optional)")
+	var topicsFile = flag.String("topics", "", "file with words that relate messages to the shop, one per line (optional)")
+	var productsFile = flag.String("products", "", "file with the products and the names customers use for them (optional)")
+	var orderPattern = flag.String("order-pattern", "", "regular expression for order numbers, with the number in the first group (optional)")
+	var cacheSize = flag.Int("cache-size", defaultCacheSize, "number of answers remembered for identical texts (0 disables caching)")
+	var cacheTTL = flag.Duration("cache-ttl", defaultCacheTTL, "time an answer is remembered")
+	var concurrency = flag.Int("concurrency", defaultConcurrency, "maximum number of texts categorised at once")
+	var dialMeasuring = measure.RegisterFlags(defaultPort)
 	flag.Parse()
 
 	lis, err := net.Listen("tcp", ":"+*port)
@@ -82,12 +92,63 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
-	pb.RegisterCategorisingServer(s, &server{})
+	recorder, err := dialMeasuring()
+	if err != nil {
+		log.Fatalf("failed to connect to measuring: %v", err)
+	}
+
+	modelFiles, err := parseModelFiles(*languageModels)
+	if err != nil {
+		log.Fatalf("invalid -language-models: %v", err)
+	}
+	if *modelFile != "" {
+		modelFiles[""] = *modelFile
+	}
+	cfg := config{
+		engine:           *engine,
+		languageEndpoint: *languageEndpoint,
+		rulesFile:        *rulesFile,
+		modelFiles:       modelFiles,
+		reloadInterval:   *reloadInterval,
+		spamPhrasesFile:  *spamPhrasesFile,
+		topicsFile:       *topicsFile,
+		cacheSize:        *cacheSize,
+		cacheTTL:         *cacheTTL,
+	}
+	if *concurrency < 1 {
+		log.Fatalf("invalid -concurrency: %v", *concurrency)
+	}
+	ex, err := newExtractor(*productsFile, *orderPattern)
+	if err != nil {
+		log.Fatalf("failed to create extractor: %v", err)
+	}
+	c, err := newClassifier(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("failed to create %s classifier: %v", *engine, err)
+	}
+
+	s := grpc.NewServer(recorder.ServerOptions()...)
+	pb.RegisterCategorisingServer(s, newServer(c, ex, *concurrency))
+	healthpb.RegisterHealthServer(s, health.NewServer())
 
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Print("INFO: shutting down")
+		s.GracefulStop()
+	}()
+
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+	if err := c.close(); err != nil {
+		log.Printf("WARN: error closing classifier: %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		log.Printf("WARN: error closing measuring: %v", err)
+	}
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeClassifier struct {
+	category messagepb.MessageCategory
+	err      error
+}
+
+func (f fakeClassifier) classify(context.Context, string, string) (*pb.GetCategoryResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &pb.GetCategoryResponse{Category: f.category}, nil
+}
+
+func (f fakeClassifier) close() error {
+	return nil
+}
+
+func TestServer_GetCategory(t *testing.T) {
+	cases := []struct {
+		engine   classifier
+		expected messagepb.MessageCategory
+		err      bool
+	}{
+		{fakeClassifier{category: messagepb.MessageCategory_FEEDBACK}, messagepb.MessageCategory_FEEDBACK, false},
+		{fakeClassifier{err: errors.New("boom")}, messagepb.MessageCategory_NONE, true},
+	}
+	for i, c := range cases {
+		resp, err := newServer(c.engine, nil, 1).GetCategory(context.Background(), &pb.GetCategoryRequest{Text: "hi"})
+		if (err != nil) != c.err {
+			t.Errorf("case %v: expected error %v, got %v", i, c.err, err)
+		}
+		if resp.GetCategory() != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, resp.GetCategory())
+		}
+	}
+}
+
+func TestNewClassifier(t *testing.T) {
+	if _, err := newClassifier(context.Background(), config{engine: "magic"}); err == nil {
+		t.Errorf("expected error for unknown engine")
+	}
+	c, err := newClassifier(context.Background(), config{engine: localEngine})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sc, ok := c.(*screeningClassifier); !ok {
+		t.Errorf("expected screening classifier, got %T", c)
+	} else if _, ok := sc.engine.(*localClassifier); !ok {
+		t.Errorf("expected local engine, got %T", sc.engine)
+	}
+
+	c, err = newClassifier(context.Background(), config{engine: localEngine, cacheSize: 10, cacheTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cc, ok := c.(*cachingClassifier); !ok {
+		t.Errorf("expected caching classifier, got %T", c)
+	} else if _, ok := cc.engine.(*screeningClassifier); !ok {
+		t.Errorf("expected screening classifier in cache, got %T", cc.engine)
+	}
+}
+
+func TestParseModelFiles(t *testing.T) {
+	cases := []struct {
+		s        string
+		expected map[string]string
+		err      bool
+	}{
+		{"", map[string]string{}, false},
+		{"fr=fr.json", map[string]string{"fr": "fr.json"}, false},
+		{"fr=fr.json, nl = nl.json,", map[string]string{"fr": "fr.json", "nl": "nl.json"}, false},
+		{"fr.json", nil, true},
+		{"=fr.json", nil, true},
+		{"fr=", nil, true},
+	}
+	for i, c := range cases {
+		actual, err := parseModelFiles(c.s)
+		if (err != nil) != c.err {
+			t.Errorf("case %v: expected error %v, got %v", i, c.err, err)
+			continue
+		}
+		if !c.err && !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestSentimentConfidences(t *testing.T) {
+	cases := []struct {
+		score    float32
+		question float64
+		expected messagepb.MessageCategory
+		min      float64
+		max      float64
+	}{
+		{-.9, 0, messagepb.MessageCategory_COMPLAINT, .95, 1},
+		{-.9, 1, messagepb.MessageCategory_COMPLAINT, .95, 1},
+		{-.2, 1, messagepb.MessageCategory_QUESTION, 1, 1},
+		{0, 0, messagepb.MessageCategory_FEEDBACK, .5, .5},
+		{.3, 0, messagepb.MessageCategory_FEEDBACK, .8, 1},
+		{.3, .4, messagepb.MessageCategory_QUESTION, .4, .4},
+		{.9, .6, messagepb.MessageCategory_QUESTION, .6, .6},
+	}
+	for i, c := range cases {
+		confidences := sentimentConfidences(c.score, c.question)
+		sum := 0.
+		for _, conf := range confidences {
+			sum += conf
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("case %v: expected confidences to add up to 1, got %v", i, sum)
+		}
+		if conf := confidences[c.expected]; conf < c.min-1e-9 || conf > c.max+1e-9 {
+			t.Errorf("case %v: expected confidence in %v within [%v, %v], got %v", i, c.expected, c.min, c.max, conf)
+		}
+	}
+}
+
+func TestNewResponse(t *testing.T) {
+	confidences := map[messagepb.MessageCategory]float64{
+		messagepb.MessageCategory_FEEDBACK:  .25,
+		messagepb.MessageCategory_COMPLAINT: .5,
+		messagepb.MessageCategory_QUESTION:  .25,
+	}
+	resp := newResponse(messagepb.MessageCategory_COMPLAINT, confidences, nil)
+	expected := []messagepb.MessageCategory{
+		messagepb.MessageCategory_COMPLAINT,
+		messagepb.MessageCategory_QUESTION,
+		messagepb.MessageCategory_FEEDBACK,
+	}
+	for i, c := range resp.GetCandidates() {
+		if c.GetCategory() != expected[i] {
+			t.Errorf("case %v: expected %v, got %v", i, expected[i], c.GetCategory())
+		}
+	}
+	if resp.GetConfidence() != .5 {
+		t.Errorf("expected confidence .5, got %v", resp.GetConfidence())
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sentimentEngine = "sentiment"
+	localEngine     = "local"
+)
+
+// A classifier determines the category of a message text, with its
+// confidence in every candidate category. The language of the text is an
+// ISO-639-1 code, or empty when unknown.
+type classifier interface {
+	classify(ctx context.Context, text, lang string) (*pb.GetCategoryResponse, error)
+	close() error
+}
+
+// Classifier settings. The rules and model settings only apply to the local
+// engine; all files may be left empty to use defaults.
+type config struct {
+	engine string
+	// address of a stand-in for the Natural Language API (optional)
+	languageEndpoint string
+	rulesFile        string
+	// model files per language; the model for the empty language is used
+	// for languages without a model of their own
+	modelFiles      map[string]string
+	reloadInterval  time.Duration
+	spamPhrasesFile string
+	topicsFile      string
+	// answers remembered for identical texts; no caching when zero
+	cacheSize int
+	cacheTTL  time.Duration
+}
+
+// Creates the classifier for the configured engine, screening texts for
+// garbage and off-topic messages and caching the answers if configured.
+func newClassifier(ctx context.Context, cfg config) (classifier, error) {
+	var engine classifier
+	var err error
+	switch cfg.engine {
+	case sentimentEngine:
+		engine, err = newSentimentClassifier(ctx, cfg.languageEndpoint)
+	case localEngine:
+		engine, err = newLocalClassifier(cfg.rulesFile, cfg.modelFiles, cfg.reloadInterval)
+	default:
+		return nil, fmt.Errorf("unknown engine %q", cfg.engine)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newScreeningClassifier(engine, cfg.spamPhrasesFile, cfg.topicsFile)
+	if err != nil {
+		_ = engine.close()
+		return nil, err
+	}
+	if cfg.cacheSize > 0 {
+		return newCachingClassifier(c, cfg.cacheSize, cfg.cacheTTL), nil
+	}
+	return c, nil
+}
+
+// Creates a response for the chosen category, listing the candidates by
+// descending confidence.
+func newResponse(cat messagepb.MessageCategory, confidences map[messagepb.MessageCategory]float64, signals *pb.CategorySignals) *pb.GetCategoryResponse {
+	resp := &pb.GetCategoryResponse{
+		Category:   cat,
+		Confidence: float32(confidences[cat]),
+		Signals:    signals,
+	}
+	for c, conf := range confidences {
+		resp.Candidates = append(resp.Candidates, &pb.CategoryCandidate{Category: c, Confidence: float32(conf)})
+	}
+	sort.Slice(resp.Candidates, func(i, j int) bool {
+		a, b := resp.Candidates[i], resp.Candidates[j]
+		if a.Confidence != b.Confidence {
+			return a.Confidence > b.Confidence
+		}
+		return a.Category < b.Category
+	})
+	return resp
+}
+
+// Finds the category with the highest confidence, provided it is unique and
+// above zero.
+func top(confidences map[messagepb.MessageCategory]float64) (messagepb.MessageCategory, bool) {
+	best, max, tied := messagepb.MessageCategory_NONE, 0., false
+	for cat, conf := range confidences {
+		if conf > max {
+			best, max, tied = cat, conf, false
+		} else if conf == max && conf > 0 {
+			tied = true
+		}
+	}
+	return best, max > 0 && !tied
+}
+
+// Parses a list of language models like "fr=model_fr.json,nl=model_nl.json"
+// into the model file per language.
+func parseModelFiles(s string) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("invalid language model %q, expected language=file", part)
+		}
+		files[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return files, nil
+}
@@ -62,6 +62,33 @@ func getCategory(host, port, m string) error {
 	return err
 }
 
+func batchGetCategory(host, port string, ms ...string) error {
+	r := &pb.BatchGetCategoryRequest{}
+	for _, m := range ms {
+		r.Requests = append(r.Requests, &pb.GetCategoryRequest{Text: m})
+	}
+
+	conn, err := getConn(host, port)
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Panicf("error closing connection: %v", err)
+		}
+	}()
+
+	c := pb.NewCategorisingClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := c.BatchGetCategory(ctx, r)
+
+	for _, cat := range resp.GetResponses() {
+		log.Printf("%v\n", cat)
+	}
+
+	return err
+}
+
 func main() {
 	var host = flag.String("host", defaultHost, "categorising service host")
 	var port = flag.String("port", defaultPort, "categorising service port")
@@ -71,4 +98,5 @@ func main() {
 	fmt.Println(getCategory(*host, *port, "Everything is awesome."))
 	fmt.Println(getCategory(*host, *port, "Ça je n'aime pas."))
 	fmt.Println(getCategory(*host, *port, "I have a question about this product. Can I eat it?"))
+	fmt.Println(batchGetCategory(*host, *port, "Where is my order?", "The knob came off.", "Lovely knobs!"))
 }
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"github.com/HayoVanLoon/protoworkflow/categorising_grpc/v1/bayes"
+	"strings"
+)
+
+// Common words per language, by which the language of a text is recognised
+var stopwords = map[string][]string{
+	"en": {"the", "a", "an", "and", "or", "but", "is", "are", "was", "it", "to", "of", "i", "you", "my",
+		"this", "that", "not", "for", "with", "have", "do", "does", "what", "how", "me", "please", "thanks",
+		"in", "on", "can", "everything", "about"},
+	"fr": {"le", "la", "les", "et", "ou", "est", "je", "ne", "pas", "de", "des", "du", "un", "une", "ce",
+		"ça", "mon", "ma", "mes", "vous", "pour", "avec", "que", "qui", "il", "elle", "merci", "bonjour",
+		"très", "au", "aux", "quand", "sur", "en"},
+	"nl": {"de", "het", "een", "en", "is", "ik", "niet", "van", "mijn", "u", "je", "dat", "die", "met",
+		"voor", "op", "te", "zijn", "heb", "wordt", "graag", "bedankt", "ook", "maar", "er", "wat", "hoe",
+		"deze", "geen", "nog"},
+}
+
+// French elided articles and pronouns, as in "l'article" or "n'aime"
+var elisions = []string{"l'", "d'", "j'", "n'", "c'", "qu'", "s'", "m'", "t'"}
+
+var stopwordLanguages = func() map[string][]string {
+	langs := make(map[string][]string)
+	for l, ws := range stopwords {
+		for _, w := range ws {
+			langs[w] = append(langs[w], l)
+		}
+	}
+	return langs
+}()
+
+// Recognises the language of a text by the common words it contains. Returns
+// an ISO-639-1 code and the share of the evidence supporting it, or an empty
+// code when the language cannot be told.
+func detectLanguage(text string) (string, float64) {
+	counts := make(map[string]int)
+	total := 0
+	for _, w := range bayes.Tokenize(text) {
+		for _, l := range stopwordLanguages[w] {
+			counts[l] += 1
+			total += 1
+		}
+		for _, e := range elisions {
+			if strings.HasPrefix(w, e) && len(w) > len(e) {
+				counts["fr"] += 1
+				total += 1
+				break
+			}
+		}
+	}
+
+	best, max, tied := "", 0, false
+	for l, n := range counts {
+		if n > max {
+			best, max, tied = l, n, false
+		} else if n == max {
+			tied = true
+		}
+	}
+	if max == 0 || tied {
+		return "", 0
+	}
+	return best, float64(max) / float64(total)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{"This knob does not turn.", "en"},
+		{"Ça je n'aime pas.", "fr"},
+		{"L'article est arrivé cassé", "fr"},
+		{"Ik heb mijn bestelling nog niet ontvangen.", "nl"},
+		{"Knob", ""},
+		{"", ""},
+		{"de", ""},
+	}
+	for i, c := range cases {
+		actual, share := detectLanguage(c.text)
+		if actual != c.expected {
+			t.Errorf("case %v: expected %q, got %q", i, c.expected, actual)
+		}
+		if actual != "" && (share <= .5 || share > 1) {
+			t.Errorf("case %v: expected share in (.5, 1], got %v", i, share)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Order numbers like "order 123456", "order no. 123456" or "#123456"; the
+// first group holds the number.
+const defaultOrderPattern = `(?i)(?:\border(?:\s+(?:number|no\.?|nr\.?))?\s*:?\s*#?|#)\s*(\d{5,})\b`
+
+var emailPattern = regexp.MustCompile(`(?i)\b[a-z0-9._%+-]+@[a-z0-9-]+(?:\.[a-z0-9-]+)*\.[a-z]{2,}\b`)
+
+// A product with the names customers use for it
+type product struct {
+	ID    string   `json:"id"`
+	Names []string `json:"names"`
+
+	res []*regexp.Regexp
+}
+
+// The catalogue used when no products file is given
+var defaultProducts = []product{
+	{ID: "jolly-knob", Names: []string{"jolly knob", "jolly knobs"}},
+	{ID: "brass-knob", Names: []string{"brass knob", "brass knobs"}},
+	{ID: "porcelain-knob", Names: []string{"porcelain knob", "porcelain knobs"}},
+	{ID: "drawer-pull", Names: []string{"drawer pull", "drawer pulls"}},
+}
+
+// Compiles the names into patterns matching them as whole words, in any case
+// and with any spacing or punctuation between the words
+func (p *product) compile() error {
+	if p.ID == "" {
+		return fmt.Errorf("product without id")
+	}
+	p.res = nil
+	for _, n := range append([]string{p.ID}, p.Names...) {
+		ws := strings.FieldsFunc(n, func(r rune) bool { return r == ' ' || r == '-' })
+		if len(ws) == 0 {
+			continue
+		}
+		for i := range ws {
+			ws[i] = regexp.QuoteMeta(ws[i])
+		}
+		re, err := regexp.Compile(`(?i)(?:^|[^\p{L}\p{N}])(` + strings.Join(ws, `[\s\p{P}]+`) + `)(?:$|[^\p{L}\p{N}])`)
+		if err != nil {
+			return err
+		}
+		p.res = append(p.res, re)
+	}
+	return nil
+}
+
+func loadProducts(path string) ([]product, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ps []product
+	if err := json.Unmarshal(bs, &ps); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// Finds products, order numbers and email addresses in texts
+type extractor struct {
+	products []product
+	orders   *regexp.Regexp
+}
+
+// Creates an extractor for the products in the file and order numbers
+// matching the pattern. Without a products file or order pattern, the
+// defaults are used.
+func newExtractor(productsFile, orderPattern string) (*extractor, error) {
+	e := &extractor{}
+	if productsFile == "" {
+		e.products = make([]product, len(defaultProducts))
+		copy(e.products, defaultProducts)
+	} else {
+		ps, err := loadProducts(productsFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load products: %v", err)
+		}
+		e.products = ps
+	}
+	for i := range e.products {
+		if err := e.products[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	if orderPattern == "" {
+		orderPattern = defaultOrderPattern
+	}
+	re, err := regexp.Compile(orderPattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid order pattern: %v", err)
+	}
+	e.orders = re
+	return e, nil
+}
+
+// An entity with the position it was found at
+type found struct {
+	pos    int
+	entity *messagepb.Entity
+}
+
+// Finds the references in a text, in order of first appearance. Each
+// reference is listed once.
+func (e *extractor) extract(text string) []*messagepb.Entity {
+	var fs []found
+	for _, p := range e.products {
+		for _, re := range p.res {
+			for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+				fs = append(fs, found{m[2], &messagepb.Entity{Type: messagepb.Entity_PRODUCT, Value: p.ID, Text: text[m[2]:m[3]]}})
+			}
+		}
+	}
+	for _, m := range e.orders.FindAllStringSubmatchIndex(text, -1) {
+		value := text[m[0]:m[1]]
+		if len(m) > 2 && m[2] >= 0 {
+			value = text[m[2]:m[3]]
+		}
+		fs = append(fs, found{m[0], &messagepb.Entity{Type: messagepb.Entity_ORDER_NUMBER, Value: value, Text: strings.TrimSpace(text[m[0]:m[1]])}})
+	}
+	for _, m := range emailPattern.FindAllStringIndex(text, -1) {
+		email := text[m[0]:m[1]]
+		fs = append(fs, found{m[0], &messagepb.Entity{Type: messagepb.Entity_EMAIL, Value: strings.ToLower(email), Text: email}})
+	}
+
+	sort.SliceStable(fs, func(i, j int) bool {
+		return fs[i].pos < fs[j].pos
+	})
+	var es []*messagepb.Entity
+	seen := make(map[string]bool)
+	for _, f := range fs {
+		k := f.entity.Type.String() + "=" + f.entity.Value
+		if !seen[k] {
+			seen[k] = true
+			es = append(es, f.entity)
+		}
+	}
+	return es
+}
+
+func (s server) ExtractEntities(_ context.Context, r *pb.ExtractEntitiesRequest) (*pb.ExtractEntitiesResponse, error) {
+	return &pb.ExtractEntitiesResponse{Entities: s.extractor.extract(r.GetText())}, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Lists entities as type=value
+func entityStrings(es []*messagepb.Entity) []string {
+	var ss []string
+	for _, e := range es {
+		ss = append(ss, e.GetType().String()+"="+e.GetValue())
+	}
+	return ss
+}
+
+func TestExtractor_extract(t *testing.T) {
+	ex, err := newExtractor("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		text     string
+		expected []string
+	}{
+		{"The Jolly  Knob came off.", []string{"PRODUCT=jolly-knob"}},
+		{"I love my jolly-knobs and the brass knob!", []string{"PRODUCT=jolly-knob", "PRODUCT=brass-knob"}},
+		{"Where is order #123456? Mail me at Bob@Example.com", []string{"ORDER_NUMBER=123456", "EMAIL=bob@example.com"}},
+		{"Order no. 654321, order nr 654321 and #777777", []string{"ORDER_NUMBER=654321", "ORDER_NUMBER=777777"}},
+		{"I ordered 123456 knobs", nil},
+		{"A jollyknob, #1234", nil},
+		{"", nil},
+	}
+	for i, c := range cases {
+		actual := entityStrings(ex.extract(c.text))
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+
+	es := ex.extract("the JOLLY knob")
+	if len(es) != 1 || es[0].GetText() != "JOLLY knob" {
+		t.Errorf("expected the text as found, got %v", es)
+	}
+}
+
+func TestNewExtractor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "categorising")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	products := write("products.json", `[{"id": "KN-01", "names": ["het vrolijke knopje"]}]`)
+	noID := write("noid.json", `[{"names": ["knob"]}]`)
+
+	cases := []struct {
+		products string
+		pattern  string
+		text     string
+		expected []string
+		err      bool
+	}{
+		{products, "", "Het vrolijke knopje, bestelling #123456", []string{"PRODUCT=KN-01", "ORDER_NUMBER=123456"}, false},
+		{products, "", "kn-01 and the jolly knob", []string{"PRODUCT=KN-01"}, false},
+		{"", `BK-\d{4}`, "BK-1234 and #123456", []string{"ORDER_NUMBER=BK-1234"}, false},
+		{"", `(?i)bestelling (\d+)`, "Bestelling 42", []string{"ORDER_NUMBER=42"}, false},
+		{noID, "", "", nil, true},
+		{filepath.Join(dir, "missing.json"), "", "", nil, true},
+		{"", "(", "", nil, true},
+	}
+	for i, c := range cases {
+		ex, err := newExtractor(c.products, c.pattern)
+		if (err != nil) != c.err {
+			t.Errorf("case %v: expected error %v, got %v", i, c.err, err)
+			continue
+		}
+		if c.err {
+			continue
+		}
+		if actual := entityStrings(ex.extract(c.text)); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestServer_ExtractEntities(t *testing.T) {
+	ex, err := newExtractor("", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := newServer(nil, ex, 1).ExtractEntities(context.Background(), &pb.ExtractEntitiesRequest{Text: "My drawer pull, order 12345"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"PRODUCT=drawer-pull", "ORDER_NUMBER=12345"}
+	if actual := entityStrings(resp.GetEntities()); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -4,8 +4,12 @@ go 1.12
 
 require (
 	cloud.google.com/go v0.37.4
-	github.com/HayoVanLoon/protoworkflow-genproto v0.0.0-20190413124918-437000fd1d5d
+	github.com/HayoVanLoon/protoworkflow-genproto v0.0.0-20190625192144-df35325a481d
+	github.com/HayoVanLoon/protoworkflow/commons v0.0.0
 	golang.org/x/net v0.0.0-20190404232315-eb5bcb51f2a3
+	google.golang.org/api v0.3.1
 	google.golang.org/genproto v0.0.0-20190404172233-64821d5d2107
 	google.golang.org/grpc v1.20.0
 )
+
+replace github.com/HayoVanLoon/protoworkflow/commons => ../../commons/v1
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package languagetest provides an in-process stand-in for the sentiment
+// analysis of the Cloud Natural Language API, for tests and local runs.
+package languagetest
+
+import (
+	"golang.org/x/net/context"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Words with their sentiment, from -1 to 1
+var DefaultLexicon = map[string]float32{
+	"awesome": 1, "great": .8, "love": .9, "lovely": .8, "nice": .6, "thanks": .5, "good": .6, "smooth": .5,
+	"bad": -.6, "broken": -.8, "terrible": -1, "awful": -1, "hate": -.9, "refund": -.5, "worst": -1, "angry": -.8,
+	"super": .8, "merci": .5, "aime": .7, "cassé": -.8, "nul": -.8,
+	"mooi": .6, "geweldig": .9, "bedankt": .5, "kapot": -.8, "slecht": -.7,
+}
+
+// Words that turn around the sentiment of the next sentiment word
+var negations = map[string]bool{
+	"not": true, "no": true, "never": true, "don't": true, "doesn't": true, "isn't": true,
+	"pas": true, "jamais": true, "niet": true, "geen": true, "nooit": true,
+}
+
+// French elided words; a negating one turns around the word it is attached to
+var elisions = map[string]bool{"n'": true, "l'": false, "d'": false, "j'": false, "c'": false, "qu'": false, "s'": false}
+
+// A sentiment as reported for a document
+type Sentiment struct {
+	Score     float32
+	Magnitude float32
+}
+
+// A Language service that scores texts by a lexicon, unless a sentiment was
+// scripted for the exact text. Calls fail with the queued errors first.
+type Fake struct {
+	mu       sync.Mutex
+	lexicon  map[string]float32
+	scripted map[string]Sentiment
+	// errors returned by the next calls
+	Errs []error
+	// number of calls made
+	Calls int
+	// the document of the last call
+	Document *languagepb.Document
+}
+
+// Creates a fake scoring by the lexicon, or by the default lexicon when nil.
+func NewFake(lexicon map[string]float32) *Fake {
+	if lexicon == nil {
+		lexicon = DefaultLexicon
+	}
+	return &Fake{lexicon: lexicon, scripted: make(map[string]Sentiment)}
+}
+
+// Makes the fake report a sentiment for a text
+func (f *Fake) Script(text string, score, magnitude float32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripted[text] = Sentiment{score, magnitude}
+}
+
+// Scores a text by the average sentiment of its lexicon words. The magnitude
+// is the total strength of the sentiment words.
+func (f *Fake) Score(text string) Sentiment {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+
+	s := Sentiment{}
+	n, negate := 0, false
+	for _, w := range words {
+		for e, negating := range elisions {
+			if strings.HasPrefix(w, e) {
+				w, negate = w[len(e):], negate || negating
+				break
+			}
+		}
+		if negations[w] {
+			negate = true
+			continue
+		}
+		v, ok := f.lexicon[w]
+		if !ok {
+			continue
+		}
+		if negate {
+			v, negate = -v, false
+		}
+		s.Score += v
+		if v < 0 {
+			s.Magnitude -= v
+		} else {
+			s.Magnitude += v
+		}
+		n += 1
+	}
+	if n > 0 {
+		s.Score /= float32(n)
+	}
+	return s
+}
+
+func (f *Fake) AnalyzeSentiment(ctx context.Context, r *languagepb.AnalyzeSentimentRequest) (*languagepb.AnalyzeSentimentResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls += 1
+	f.Document = r.GetDocument()
+	if len(f.Errs) > 0 {
+		err := f.Errs[0]
+		f.Errs = f.Errs[1:]
+		return nil, err
+	}
+
+	text := r.GetDocument().GetContent()
+	if text == "" {
+		return nil, status.Error(codes.InvalidArgument, "document has no content")
+	}
+	s, ok := f.scripted[text]
+	if !ok {
+		s = f.Score(text)
+	}
+
+	lang := r.GetDocument().GetLanguage()
+	if lang == "" {
+		lang = "en"
+	}
+	return &languagepb.AnalyzeSentimentResponse{
+		DocumentSentiment: &languagepb.Sentiment{Score: s.Score, Magnitude: s.Magnitude},
+		Language:          lang,
+	}, nil
+}
+
+func (f *Fake) AnalyzeEntities(context.Context, *languagepb.AnalyzeEntitiesRequest) (*languagepb.AnalyzeEntitiesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "not supported by fake")
+}
+
+func (f *Fake) AnalyzeEntitySentiment(context.Context, *languagepb.AnalyzeEntitySentimentRequest) (*languagepb.AnalyzeEntitySentimentResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "not supported by fake")
+}
+
+func (f *Fake) AnalyzeSyntax(context.Context, *languagepb.AnalyzeSyntaxRequest) (*languagepb.AnalyzeSyntaxResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "not supported by fake")
+}
+
+func (f *Fake) ClassifyText(context.Context, *languagepb.ClassifyTextRequest) (*languagepb.ClassifyTextResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "not supported by fake")
+}
+
+func (f *Fake) AnnotateText(context.Context, *languagepb.AnnotateTextRequest) (*languagepb.AnnotateTextResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "not supported by fake")
+}
+
+// Serves the fake on a free local port. Returns its address and a function
+// for stopping it.
+func Serve(f *Fake) (string, func(), error) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", nil, err
+	}
+	s := grpc.NewServer()
+	languagepb.RegisterLanguageServiceServer(s, f)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	return lis.Addr().String(), s.Stop, nil
+}
@@ -0,0 +1,336 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/categorising_grpc/v1/bayes"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Category for texts that match no rule and cannot be scored by a model.
+const fallbackCategory = messagepb.MessageCategory_QUESTION
+
+// A rule awards a hit to its category for every keyword found in a text and
+// for a match of its pattern. Rules with a language only apply to texts in
+// that language, or in an unknown one.
+type rule struct {
+	Category string   `json:"category"`
+	Language string   `json:"language"`
+	Keywords []string `json:"keywords"`
+	Pattern  string   `json:"pattern"`
+
+	category messagepb.MessageCategory
+	re       *regexp.Regexp
+}
+
+// Rules used when no rules file is provided.
+var defaultRules = []rule{
+	{
+		Category: "COMPLAINT",
+		Language: "en",
+		Keywords: []string{"broken", "refund", "disappointed", "terrible", "awful", "worst", "not please", "damaged"},
+	},
+	{
+		Category: "FEEDBACK",
+		Language: "en",
+		Keywords: []string{"awesome", "great", "love", "thanks", "thank you", "excellent", "wonderful"},
+	},
+	{
+		Category: "COMPLAINT",
+		Language: "fr",
+		Keywords: []string{"cassé", "remboursement", "déçu", "déçue", "nul", "n'aime pas", "abîmé", "horrible"},
+	},
+	{
+		Category: "FEEDBACK",
+		Language: "fr",
+		Keywords: []string{"merci", "magnifique", "super", "génial", "excellent", "j'adore", "parfait"},
+	},
+	{
+		Category: "COMPLAINT",
+		Language: "nl",
+		Keywords: []string{"kapot", "terugbetaling", "teleurgesteld", "ontevreden", "slecht", "beschadigd", "waardeloos"},
+	},
+	{
+		Category: "FEEDBACK",
+		Language: "nl",
+		Keywords: []string{"bedankt", "dank je", "prima", "geweldig", "mooi", "top", "tevreden"},
+	},
+}
+
+func (r rule) appliesTo(lang string) bool {
+	return r.Language == "" || lang == "" || r.Language == lang
+}
+
+func (r *rule) compile() error {
+	v, ok := messagepb.MessageCategory_value[r.Category]
+	if !ok {
+		return fmt.Errorf("unknown category %q", r.Category)
+	}
+	r.category = messagepb.MessageCategory(v)
+
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern for %s: %v", r.Category, err)
+		}
+		r.re = re
+	}
+	for i, k := range r.Keywords {
+		r.Keywords[i] = strings.TrimSpace(normalise(k))
+	}
+	return nil
+}
+
+// Collects the keywords and the pattern of the rule found in a text.
+// Keywords are looked up in words, the normalised form of the text.
+func (r rule) match(text, words string) (keywords []string, pattern bool) {
+	for _, k := range r.Keywords {
+		if strings.Contains(words, " "+k+" ") {
+			keywords = append(keywords, k)
+		}
+	}
+	return keywords, r.re != nil && r.re.MatchString(text)
+}
+
+// Reduces the text to its lower case words separated and surrounded by single
+// spaces, so keywords only match whole words.
+func normalise(text string) string {
+	return " " + strings.Join(bayes.Tokenize(text), " ") + " "
+}
+
+func loadRules(path string) ([]rule, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rs []rule
+	if err := json.Unmarshal(bs, &rs); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// Categorises texts without network access, using keyword and pattern rules
+// with optional naive Bayes models for texts the rules cannot decide. Models
+// are reloaded when their files change.
+type localClassifier struct {
+	rules []rule
+	// models per language; the one for the empty language is the default
+	models map[string]*modelSource
+	done   chan struct{}
+}
+
+// Creates a local classifier. Without a rules file the default rules are
+// used. Model files are given per language, with the empty language for the
+// default model. With a positive reload interval, model files are checked
+// for changes at that interval.
+func newLocalClassifier(rulesFile string, modelFiles map[string]string, reloadInterval time.Duration) (*localClassifier, error) {
+	c := &localClassifier{models: make(map[string]*modelSource), done: make(chan struct{})}
+
+	if rulesFile == "" {
+		c.rules = make([]rule, len(defaultRules))
+		for i, r := range defaultRules {
+			c.rules[i] = r
+			c.rules[i].Keywords = append([]string(nil), r.Keywords...)
+		}
+	} else {
+		rs, err := loadRules(rulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load rules: %v", err)
+		}
+		c.rules = rs
+	}
+	for i := range c.rules {
+		if err := c.rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	for lang, path := range modelFiles {
+		src := &modelSource{path: path}
+		if _, err := src.reload(); err != nil {
+			return nil, fmt.Errorf("could not load model %s: %v", path, err)
+		}
+		c.models[lang] = src
+	}
+	if len(c.models) > 0 && reloadInterval > 0 {
+		go c.watch(reloadInterval)
+	}
+	return c, nil
+}
+
+func (c *localClassifier) watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			for _, src := range c.models {
+				if ok, err := src.reload(); err != nil {
+					log.Printf("WARN: could not reload model: %v", err)
+				} else if ok {
+					log.Printf("INFO: reloaded model %s", src.path)
+				}
+			}
+		}
+	}
+}
+
+// Provides the model for a language, falling back to the default model
+func (c *localClassifier) model(lang string) *bayes.Model {
+	if src, ok := c.models[lang]; ok {
+		return src.current()
+	}
+	if src, ok := c.models[""]; ok {
+		return src.current()
+	}
+	return nil
+}
+
+// Identifies the models in use, changing whenever one of them is reloaded
+func (c *localClassifier) version() string {
+	var vs []string
+	for lang, src := range c.models {
+		vs = append(vs, lang+"="+src.version())
+	}
+	sort.Strings(vs)
+	return strings.Join(vs, ",")
+}
+
+func (c *localClassifier) classify(_ context.Context, text, lang string) (*pb.GetCategoryResponse, error) {
+	confidences, signals := c.applyRules(text, lang)
+	cat, ok := top(confidences)
+	if model := c.model(lang); !ok && model != nil {
+		confidences = modelConfidences(model, text)
+		cat, ok = top(confidences)
+	}
+	if !ok {
+		cat = fallbackCategory
+	}
+	return newResponse(cat, confidences, signals), nil
+}
+
+// Derives the confidence in each category from its share of the rule hits.
+// Every question cue in the text counts as a hit for QUESTION.
+func (c *localClassifier) applyRules(text, lang string) (map[messagepb.MessageCategory]float64, *pb.CategorySignals) {
+	words := normalise(text)
+	q, cues := questionScore(text, lang)
+	signals := &pb.CategorySignals{QuestionScore: float32(q), QuestionCues: cues}
+	hits := map[messagepb.MessageCategory]int{messagepb.MessageCategory_QUESTION: len(cues)}
+	total := len(cues)
+	for _, r := range c.rules {
+		if !r.appliesTo(lang) {
+			continue
+		}
+		ks, p := r.match(text, words)
+		signals.MatchedKeywords = append(signals.MatchedKeywords, ks...)
+		n := len(ks)
+		if p {
+			signals.MatchedPatterns = append(signals.MatchedPatterns, r.Pattern)
+			n += 1
+		}
+		hits[r.category] += n
+		total += n
+	}
+
+	confidences := make(map[messagepb.MessageCategory]float64)
+	for cat, n := range hits {
+		if total > 0 {
+			confidences[cat] = float64(n) / float64(total)
+		}
+	}
+	return confidences, signals
+}
+
+func modelConfidences(model *bayes.Model, text string) map[messagepb.MessageCategory]float64 {
+	confidences := make(map[messagepb.MessageCategory]float64)
+	for l, p := range model.Scores(text) {
+		if v, ok := messagepb.MessageCategory_value[l]; ok {
+			confidences[messagepb.MessageCategory(v)] = p
+		}
+	}
+	return confidences
+}
+
+func (c *localClassifier) close() error {
+	close(c.done)
+	return nil
+}
+
+// A model file that is reloaded when it changes
+type modelSource struct {
+	path string
+
+	mu      sync.RWMutex
+	model   *bayes.Model
+	modTime time.Time
+}
+
+// Loads the model file if it has changed since it was last loaded. Reports
+// whether a new model was loaded. A model that fails to load leaves the
+// current one in place.
+func (s *modelSource) reload() (bool, error) {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	unchanged := fi.ModTime().Equal(s.modTime)
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	m, err := bayes.Load(s.path)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.model, s.modTime = m, fi.ModTime()
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Identifies the loaded model by its version and file modification time
+func (s *modelSource) version() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fmt.Sprintf("%s@%d", s.model.Version, s.modTime.UnixNano())
+}
+
+func (s *modelSource) current() *bayes.Model {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.model
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/categorising_grpc/v1/bayes"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLocalClassifier_classify(t *testing.T) {
+	model := bayes.New()
+	model.Add("COMPLAINT", "the knob fell off")
+	model.Add("FEEDBACK", "the knob turns smoothly")
+
+	french := bayes.New()
+	french.Add("OTHER", "the knob fell off")
+
+	cases := []struct {
+		model    *bayes.Model
+		lang     string
+		text     string
+		expected messagepb.MessageCategory
+	}{
+		{nil, "", "This does not please me.", messagepb.MessageCategory_COMPLAINT},
+		{nil, "en", "Everything is awesome.", messagepb.MessageCategory_FEEDBACK},
+		{nil, "", "Ça je n'aime pas.", messagepb.MessageCategory_COMPLAINT},
+		{nil, "fr", "Ça je n'aime pas.", messagepb.MessageCategory_COMPLAINT},
+		{nil, "en", "Ça je n'aime pas.", fallbackCategory},
+		{nil, "nl", "Bedankt, mooie knop", messagepb.MessageCategory_FEEDBACK},
+		{nil, "nl", "Mijn knop is kapot", messagepb.MessageCategory_COMPLAINT},
+		{nil, "", "I have a question about this product. Can I eat it?", messagepb.MessageCategory_QUESTION},
+		{nil, "", "Refund, it is BROKEN!", messagepb.MessageCategory_COMPLAINT},
+		{nil, "", "The knob fell off.", fallbackCategory},
+		{model, "", "The knob fell off.", messagepb.MessageCategory_COMPLAINT},
+		{model, "en", "It turns smoothly", messagepb.MessageCategory_FEEDBACK},
+		{model, "fr", "The knob fell off.", messagepb.MessageCategory_OTHER},
+		{nil, "", "Great, but broken", fallbackCategory},
+	}
+	for i, c := range cases {
+		lc, err := newLocalClassifier("", nil, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c.model != nil {
+			lc.models[""] = &modelSource{model: c.model}
+			lc.models["fr"] = &modelSource{model: french}
+		}
+
+		resp, err := lc.classify(context.Background(), c.text, c.lang)
+		if err != nil {
+			t.Errorf("case %v: unexpected error %v", i, err)
+		}
+		if actual := resp.GetCategory(); actual != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestNewLocalClassifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "categorising")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	rules := write("rules.json", `[{"category": "FEEDBACK", "keywords": ["Smooth Turning"]}, {"category": "COMPLAINT", "language": "nl", "pattern": "^!+$"}]`)
+	unknown := write("unknown.json", `[{"category": "NONSENSE"}]`)
+	badPattern := write("bad.json", `[{"category": "OTHER", "pattern": "("}]`)
+	model := write("model.json", `{"classes": {"OTHER": {"documents": 1, "tokens": 1, "counts": {"weather": 1}}}}`)
+
+	cases := []struct {
+		rules    string
+		model    string
+		lang     string
+		text     string
+		expected messagepb.MessageCategory
+		err      bool
+	}{
+		{rules, "", "", "such smooth turning", messagepb.MessageCategory_FEEDBACK, false},
+		{rules, "", "nl", "!!!", messagepb.MessageCategory_COMPLAINT, false},
+		{rules, "", "en", "!!!", fallbackCategory, false},
+		{rules, "", "", "awesome", fallbackCategory, false},
+		{rules, model, "", "nice weather", messagepb.MessageCategory_OTHER, false},
+		{unknown, "", "", "", 0, true},
+		{badPattern, "", "", "", 0, true},
+		{filepath.Join(dir, "missing.json"), "", "", "", 0, true},
+		{"", filepath.Join(dir, "missing.json"), "", "", 0, true},
+	}
+	for i, c := range cases {
+		models := make(map[string]string)
+		if c.model != "" {
+			models[""] = c.model
+		}
+		lc, err := newLocalClassifier(c.rules, models, 0)
+		if c.err {
+			if err == nil {
+				t.Errorf("case %v: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %v: unexpected error %v", i, err)
+			continue
+		}
+		if resp, _ := lc.classify(context.Background(), c.text, c.lang); resp.GetCategory() != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, resp.GetCategory())
+		}
+	}
+}
+
+func TestLocalClassifier_reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "categorising")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "model.json")
+	m := bayes.New()
+	m.Add("COMPLAINT", "knob fell off")
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	lc, err := newLocalClassifier("", map[string]string{"": path}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer lc.close()
+	src := lc.models[""]
+	before := lc.version()
+
+	if ok, err := src.reload(); ok || err != nil {
+		t.Errorf("expected no reload for unchanged file, got %v, %v", ok, err)
+	}
+
+	m = bayes.New()
+	m.Add("OTHER", "knob fell off")
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := src.reload(); !ok || err != nil {
+		t.Errorf("expected reload for changed file, got %v, %v", ok, err)
+	}
+	if lc.version() == before {
+		t.Errorf("expected new version after reload, got %v", before)
+	}
+	if resp, _ := lc.classify(context.Background(), "knob fell off", ""); resp.GetCategory() != messagepb.MessageCategory_OTHER {
+		t.Errorf("expected reloaded model to be used, got %v", resp.GetCategory())
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.reload(); err == nil {
+		t.Errorf("expected error for corrupt model")
+	}
+	if resp, _ := lc.classify(context.Background(), "knob fell off", ""); resp.GetCategory() != messagepb.MessageCategory_OTHER {
+		t.Errorf("expected previous model to be kept, got %v", resp.GetCategory())
+	}
+}
+
+func TestLocalClassifier_confidence(t *testing.T) {
+	lc, err := newLocalClassifier("", nil, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resp, _ := lc.classify(context.Background(), "Great, but broken. Refund?", "en")
+	if resp.GetCategory() != messagepb.MessageCategory_COMPLAINT {
+		t.Errorf("expected %v, got %v", messagepb.MessageCategory_COMPLAINT, resp.GetCategory())
+	}
+	if resp.GetConfidence() != .5 {
+		t.Errorf("expected confidence .5, got %v", resp.GetConfidence())
+	}
+	expected := []*pb.CategoryCandidate{
+		{Category: messagepb.MessageCategory_COMPLAINT, Confidence: .5},
+		{Category: messagepb.MessageCategory_QUESTION, Confidence: .25},
+		{Category: messagepb.MessageCategory_FEEDBACK, Confidence: .25},
+	}
+	if !reflect.DeepEqual(resp.GetCandidates(), expected) {
+		t.Errorf("expected candidates %v, got %v", expected, resp.GetCandidates())
+	}
+	if ks := resp.GetSignals().GetMatchedKeywords(); !reflect.DeepEqual(ks, []string{"broken", "refund", "great"}) {
+		t.Errorf("expected matched keywords, got %v", ks)
+	}
+	if cs := resp.GetSignals().GetQuestionCues(); !reflect.DeepEqual(cs, []string{"question-mark"}) {
+		t.Errorf("expected question cues, got %v", cs)
+	}
+
+	resp, _ = lc.classify(context.Background(), "The knob fell off.", "en")
+	if resp.GetCategory() != fallbackCategory || resp.GetConfidence() != 0 {
+		t.Errorf("expected fallback without confidence, got %v", resp)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	customerspb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
+	"github.com/golang/protobuf/proto"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	maxPriority = 100
+	// the strength of emotion that adds the full weight
+	fullMagnitude = 4.
+	// the most that emotion and urgent words can each add
+	magnitudeWeight = 40.
+	urgentWeight    = 40.
+)
+
+// A word that calls for a quick answer and the weight it adds
+type urgentWord struct {
+	word   string
+	weight float64
+}
+
+// Urgent words, per language
+var urgentWords = map[string][]urgentWord{
+	"en": {{"urgent", 30}, {"urgently", 30}, {"asap", 30}, {"emergency", 30}, {"immediately", 20},
+		{"refund", 20}, {"money back", 20}, {"chargeback", 30}},
+	"fr": {{"urgent", 30}, {"urgente", 30}, {"urgence", 30}, {"immédiatement", 20},
+		{"remboursement", 20}, {"rembourser", 20}},
+	"nl": {{"dringend", 30}, {"spoed", 30}, {"urgent", 30}, {"onmiddellijk", 20},
+		{"terugbetaling", 20}, {"geld terug", 20}},
+}
+
+// The weight added by the service level of the customer
+var tierWeights = map[customerspb.Customer_Tier]float64{
+	customerspb.Customer_SILVER: 10,
+	customerspb.Customer_GOLD:   20,
+}
+
+// Urgent words per language, normalised; the list for the empty language
+// covers all
+var urgentLexicons = func() map[string][]urgentWord {
+	lexicons := make(map[string][]urgentWord)
+	seen := make(map[string]bool)
+	for l, ws := range urgentWords {
+		for _, w := range ws {
+			w.word = strings.TrimSpace(normalise(w.word))
+			lexicons[l] = append(lexicons[l], w)
+			if !seen[w.word] {
+				lexicons[""] = append(lexicons[""], w)
+				seen[w.word] = true
+			}
+		}
+	}
+	sort.Slice(lexicons[""], func(i, j int) bool { return lexicons[""][i].word < lexicons[""][j].word })
+	return lexicons
+}()
+
+// Rates how urgently a text needs an answer, from 0 to 100, listing the
+// urgent words found. Strong emotion and urgent words add up to 40 each and
+// the customer tier up to 20. Only the words of the language of the text are
+// considered, if it is known.
+func priorityScore(text, lang string, magnitude float32, tier customerspb.Customer_Tier) (int32, []string) {
+	lex, ok := urgentLexicons[lang]
+	if !ok {
+		lex = urgentLexicons[""]
+	}
+	urgency := 0.
+	var found []string
+	words := normalise(text)
+	for _, w := range lex {
+		if strings.Contains(words, " "+w.word+" ") {
+			urgency += w.weight
+			found = append(found, w.word)
+		}
+	}
+
+	score := magnitudeWeight*math.Min(float64(magnitude)/fullMagnitude, 1) +
+		math.Min(urgency, urgentWeight) +
+		tierWeights[tier]
+	return int32(math.Min(math.Round(score), maxPriority)), found
+}
+
+// Sets the priority of a categorisation for the sender. Responses may come
+// from the cache, so a copy is returned.
+func withPriority(resp *pb.GetCategoryResponse, text string, tier customerspb.Customer_Tier) *pb.GetCategoryResponse {
+	resp = proto.Clone(resp).(*pb.GetCategoryResponse)
+	if resp.Signals == nil {
+		resp.Signals = &pb.CategorySignals{}
+	}
+	resp.Priority, resp.Signals.UrgentKeywords = priorityScore(text, resp.GetLanguageCode(), resp.Signals.GetSentimentMagnitude(), tier)
+	return resp
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	customerspb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
+	"golang.org/x/net/context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPriorityScore(t *testing.T) {
+	cases := []struct {
+		text      string
+		lang      string
+		magnitude float32
+		tier      customerspb.Customer_Tier
+		score     int32
+		expected  []string
+	}{
+		{"", "", 0, customerspb.Customer_TIER_UNSPECIFIED, 0, nil},
+		{"The knob arrived.", "en", 0, customerspb.Customer_STANDARD, 0, nil},
+		{"I want a refund, urgently!", "en", 0, customerspb.Customer_STANDARD, 40, []string{"urgently", "refund"}},
+		{"Urgent", "", 2, customerspb.Customer_GOLD, 70, []string{"urgent"}},
+		{"C'est urgent, je veux un remboursement", "fr", 8, customerspb.Customer_GOLD, 100, []string{"urgent", "remboursement"}},
+		{"Dringend", "en", 0, customerspb.Customer_SILVER, 10, nil},
+		{"Ik wil mijn geld terug", "nl", 1, customerspb.Customer_TIER_UNSPECIFIED, 30, []string{"geld terug"}},
+	}
+	for i, c := range cases {
+		score, words := priorityScore(c.text, c.lang, c.magnitude, c.tier)
+		if score != c.score {
+			t.Errorf("case %v: expected score %v, got %v", i, c.score, score)
+		}
+		if !reflect.DeepEqual(words, c.expected) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, words)
+		}
+	}
+}
+
+func TestServer_GetCategoryPriority(t *testing.T) {
+	engine := &countingClassifier{}
+	s := newServer(newCachingClassifier(engine, 10, time.Minute), nil, 1)
+
+	cases := []struct {
+		tier     customerspb.Customer_Tier
+		expected int32
+	}{
+		{customerspb.Customer_GOLD, 50},
+		{customerspb.Customer_STANDARD, 30},
+		{customerspb.Customer_SILVER, 40},
+	}
+	for i, c := range cases {
+		resp, err := s.GetCategory(context.Background(), &pb.GetCategoryRequest{Text: "Urgent, please", CustomerTier: c.tier})
+		if err != nil {
+			t.Fatalf("case %v: unexpected error: %v", i, err)
+		}
+		if resp.GetPriority() != c.expected {
+			t.Errorf("case %v: expected priority %v, got %v", i, c.expected, resp.GetPriority())
+		}
+	}
+	if engine.calls != 1 {
+		t.Errorf("expected the tier not to affect caching, got %v engine calls", engine.calls)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"github.com/HayoVanLoon/protoworkflow/categorising_grpc/v1/bayes"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Words that open a question, per language
+var interrogatives = map[string][]string{
+	"en": {"what", "how", "why", "when", "where", "which", "who", "whom", "whose", "can", "could",
+		"would", "will", "do", "does", "did", "is", "are", "was", "were", "should", "may", "have", "has"},
+	"fr": {"qui", "que", "qu'est", "quoi", "quand", "où", "comment", "pourquoi", "quel", "quelle",
+		"quels", "quelles", "combien", "est", "pouvez", "puis"},
+	"nl": {"wat", "hoe", "waarom", "wanneer", "waar", "welk", "welke", "wie", "kan", "kunt", "kun",
+		"mag", "moet", "heeft", "hebt", "hebben", "zijn", "wordt", "weet"},
+}
+
+// Phrases that introduce a question without asking it directly, per language
+var questionPhrases = map[string][]string{
+	"en": {"i wonder", "i would like to know", "i'd like to know", "please let me know", "is it possible"},
+	"fr": {"je voudrais savoir", "je me demande", "est ce que", "est ce qu'il"},
+	"nl": {"ik vraag me af", "ik wil graag weten", "ik zou graag willen weten", "kunt u mij vertellen"},
+}
+
+var (
+	sentenceEnd = regexp.MustCompile(`[.!?;\n]+`)
+	// French subject-verb inversion, as in "avez-vous" or "a-t-il"
+	inversion = regexp.MustCompile(`(?i)\p{L}+(-t)?-(je|tu|il|elle|on|nous|vous|ils|elles)\b`)
+)
+
+// The interrogatives and question phrases of a language
+type questionLexicon struct {
+	interrogatives map[string]bool
+	phrases        []string
+}
+
+// Lexicons per language; the one for the empty language covers all
+var questionLexicons = func() map[string]questionLexicon {
+	lexicons := map[string]questionLexicon{"": {interrogatives: make(map[string]bool)}}
+	for l, ws := range interrogatives {
+		lex := questionLexicon{interrogatives: make(map[string]bool)}
+		for _, w := range ws {
+			lex.interrogatives[w] = true
+			lexicons[""].interrogatives[w] = true
+		}
+		for _, p := range questionPhrases[l] {
+			lex.phrases = append(lex.phrases, strings.TrimSpace(normalise(p)))
+		}
+		lexicons[l] = lex
+	}
+	all := lexicons[""]
+	for _, ps := range questionPhrases {
+		for _, p := range ps {
+			all.phrases = append(all.phrases, strings.TrimSpace(normalise(p)))
+		}
+	}
+	sort.Strings(all.phrases)
+	lexicons[""] = all
+	return lexicons
+}()
+
+// Rates how much a text reads as a question, from 0 to 1, listing the cues
+// found: question marks, sentences opening with an interrogative, French
+// inversion and phrases announcing a question. Only the words of the
+// language of the text are considered, if it is known; inversion is only
+// looked for in French or unknown languages.
+func questionScore(text, lang string) (float64, []string) {
+	lex, ok := questionLexicons[lang]
+	if !ok {
+		lex = questionLexicons[""]
+	}
+	score := 0.
+	var cues []string
+	if strings.ContainsAny(text, "?？¿") {
+		score += .6
+		cues = append(cues, "question-mark")
+	}
+	for _, s := range sentenceEnd.Split(text, -1) {
+		if ws := bayes.Tokenize(s); len(ws) > 0 && lex.interrogatives[ws[0]] {
+			score += .4
+			cues = append(cues, "interrogative:"+ws[0])
+			break
+		}
+	}
+	if (lang == "fr" || lang == "") && inversion.MatchString(text) {
+		score += .5
+		cues = append(cues, "inversion")
+	}
+	words := normalise(text)
+	for _, p := range lex.phrases {
+		if strings.Contains(words, " "+p+" ") {
+			score += .5
+			cues = append(cues, "phrase:"+p)
+		}
+	}
+	return math.Min(score, 1), cues
+}
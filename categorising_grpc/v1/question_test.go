@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestQuestionScore(t *testing.T) {
+	cases := []struct {
+		text     string
+		score    float64
+		expected []string
+	}{
+		{"", 0, nil},
+		{"The knob arrived.", 0, nil},
+		{"Is it brass?", 1, []string{"question-mark", "interrogative:is"}},
+		{"It arrived. How do I mount it", .4, []string{"interrogative:how"}},
+		{"Avez-vous ce bouton en noir", .5, []string{"inversion"}},
+		{"Je voudrais savoir le prix.", .5, []string{"phrase:je voudrais savoir"}},
+		{"Waarom is de knop rood?", 1, []string{"question-mark", "interrogative:waarom"}},
+		{"Red?", .6, []string{"question-mark"}},
+	}
+	for i, c := range cases {
+		score, cues := questionScore(c.text, "")
+		if score != c.score {
+			t.Errorf("case %v: expected score %v, got %v", i, c.score, score)
+		}
+		if !reflect.DeepEqual(cues, c.expected) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, cues)
+		}
+	}
+
+	langCases := []struct {
+		text     string
+		lang     string
+		expected []string
+	}{
+		{"Avez-vous ce bouton en noir", "fr", []string{"inversion"}},
+		{"I would like a follow-on order", "en", nil},
+		{"I would like a follow-on order", "", []string{"inversion"}},
+		{"Een knop-je voor de deur", "nl", nil},
+	}
+	for i, c := range langCases {
+		if _, cues := questionScore(c.text, c.lang); !reflect.DeepEqual(cues, c.expected) {
+			t.Errorf("language case %v: expected %v, got %v", i, c.expected, cues)
+		}
+	}
+}
+
+// Checks the categorisation of the sample texts in testdata/corpus.json,
+// given their sentiment.
+func TestSentimentResponse_corpus(t *testing.T) {
+	bs, err := ioutil.ReadFile("testdata/corpus.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var corpus []struct {
+		Text      string
+		Sentiment float32
+		Category  string
+	}
+	if err := json.Unmarshal(bs, &corpus); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range corpus {
+		lang, _ := detectLanguage(c.Text)
+		resp := sentimentResponse(c.Text, lang, c.Sentiment, 0)
+		if resp.GetCategory().String() != c.Category {
+			t.Errorf("case %v: expected %s for %q, got %v (%v)", i, c.Category, c.Text, resp.GetCategory(), resp.GetSignals())
+		}
+	}
+}
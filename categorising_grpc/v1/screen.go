@@ -0,0 +1,268 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/categorising_grpc/v1/bayes"
+	"golang.org/x/net/context"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// texts scoring at least this are GARBAGE
+	garbageThreshold = .6
+	// off-topic texts the engine is less confident about are OTHER
+	offTopicThreshold = .5
+
+	maxLinks          = 2
+	maxLinkDensity    = .25
+	minRepeatedTokens = 8
+	maxTokenShare     = .3
+	maxCharRun        = 10
+	minShoutLetters   = 20
+	maxUpperShare     = .7
+)
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S*`)
+
+// Phrases used when no spam phrases file is provided
+var defaultSpamPhrases = []string{
+	"viagra", "casino", "bitcoin", "crypto", "lottery", "click here", "free money", "work from home",
+	"you have won", "seo services", "buy followers", "unsubscribe",
+}
+
+// Words that tie a text to the shop, used when no topics file is provided
+var defaultTopics = []string{
+	"knob", "knobs", "order", "ordered", "delivery", "delivered", "shipping", "shipped", "product",
+	"price", "payment", "paid", "invoice", "refund", "return", "stock", "shop", "store", "purchase",
+	"buy", "bought", "package", "parcel", "warranty", "account", "website", "broken", "service",
+}
+
+// Screens texts before categorising them: junk is filed as GARBAGE without
+// consulting the engine, and off-topic texts the engine is unsure about are
+// filed as OTHER.
+type screeningClassifier struct {
+	engine  classifier
+	phrases []string
+	topics  map[string]bool
+}
+
+// Creates a screening classifier around an engine. Without a spam phrases or
+// topics file, the defaults are used.
+func newScreeningClassifier(engine classifier, phrasesFile, topicsFile string) (*screeningClassifier, error) {
+	phrases, topics := defaultSpamPhrases, defaultTopics
+	var err error
+	if phrasesFile != "" {
+		if phrases, err = loadLines(phrasesFile); err != nil {
+			return nil, err
+		}
+	}
+	if topicsFile != "" {
+		if topics, err = loadLines(topicsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &screeningClassifier{engine: engine, topics: make(map[string]bool)}
+	for _, p := range phrases {
+		if n := strings.TrimSpace(normalise(p)); n != "" {
+			c.phrases = append(c.phrases, n)
+		}
+	}
+	for _, t := range topics {
+		for _, w := range bayes.Tokenize(t) {
+			c.topics[w] = true
+		}
+	}
+	return c, nil
+}
+
+// Reads the non-empty lines of a file; lines starting with '#' are skipped.
+func loadLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ls []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		l := strings.TrimSpace(sc.Text())
+		if l != "" && !strings.HasPrefix(l, "#") {
+			ls = append(ls, l)
+		}
+	}
+	return ls, sc.Err()
+}
+
+// Categorises the text, detecting its language when not given.
+func (c *screeningClassifier) classify(ctx context.Context, text, lang string) (*pb.GetCategoryResponse, error) {
+	if lang == "" {
+		lang, _ = detectLanguage(text)
+	}
+
+	links := len(linkPattern.FindAllString(text, -1))
+	words := bayes.Tokenize(linkPattern.ReplaceAllString(text, " "))
+	score, indicators := c.garbageScore(text, words, links)
+
+	if score >= garbageThreshold {
+		signals := &pb.CategorySignals{GarbageScore: float32(score), GarbageIndicators: indicators}
+		confidences := map[messagepb.MessageCategory]float64{messagepb.MessageCategory_GARBAGE: score}
+		resp := newResponse(messagepb.MessageCategory_GARBAGE, confidences, signals)
+		resp.LanguageCode = lang
+		return resp, nil
+	}
+
+	resp, err := c.engine.classify(ctx, text, lang)
+	if err != nil {
+		return nil, err
+	}
+	resp.LanguageCode = lang
+	if resp.Signals == nil {
+		resp.Signals = &pb.CategorySignals{}
+	}
+	resp.Signals.GarbageScore = float32(score)
+	resp.Signals.GarbageIndicators = indicators
+	resp.Signals.OnTopic = c.onTopic(words)
+
+	if !resp.Signals.OnTopic && resp.GetConfidence() < offTopicThreshold {
+		asOther(resp)
+	}
+	return resp, nil
+}
+
+// Files the response under OTHER, with the confidence the engine lacked. The
+// engine candidates keep their share of the rest.
+func asOther(resp *pb.GetCategoryResponse) {
+	conf := resp.GetConfidence()
+	for _, cand := range resp.Candidates {
+		cand.Confidence *= conf
+	}
+	other := &pb.CategoryCandidate{Category: messagepb.MessageCategory_OTHER, Confidence: 1 - conf}
+	resp.Candidates = append([]*pb.CategoryCandidate{other}, resp.Candidates...)
+	resp.Category = messagepb.MessageCategory_OTHER
+	resp.Confidence = other.Confidence
+}
+
+// Rates how likely a text is junk, from 0 to 1, listing the indicators found.
+// The words are those of the text without its links.
+func (c *screeningClassifier) garbageScore(text string, words []string, links int) (float64, []string) {
+	if len(words) == 0 && links == 0 {
+		return 1, []string{"empty"}
+	}
+
+	score := 0.
+	var indicators []string
+	if links > maxLinks || float64(links)/float64(links+len(words)) > maxLinkDensity {
+		score += .6
+		indicators = append(indicators, "links")
+	}
+	if repetitive(words) {
+		score += .6
+		indicators = append(indicators, "repeated-words")
+	}
+	if longestRun(text) >= maxCharRun {
+		score += .3
+		indicators = append(indicators, "repeated-characters")
+	}
+	padded := " " + strings.Join(words, " ") + " "
+	for _, p := range c.phrases {
+		if strings.Contains(padded, " "+p+" ") {
+			score += .4
+			indicators = append(indicators, "phrase:"+p)
+		}
+	}
+	if shouting(text) {
+		score += .2
+		indicators = append(indicators, "shouting")
+	}
+	return math.Min(score, 1), indicators
+}
+
+func (c *screeningClassifier) onTopic(words []string) bool {
+	for _, w := range words {
+		if c.topics[w] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *screeningClassifier) version() string {
+	return versionOf(c.engine)
+}
+
+func (c *screeningClassifier) close() error {
+	return c.engine.close()
+}
+
+// Reports whether a single word makes up a large part of a longer text
+func repetitive(words []string) bool {
+	if len(words) < minRepeatedTokens {
+		return false
+	}
+	counts := make(map[string]int)
+	max := 0
+	for _, w := range words {
+		counts[w] += 1
+		if counts[w] > max {
+			max = counts[w]
+		}
+	}
+	return float64(max)/float64(len(words)) > maxTokenShare
+}
+
+// Finds the length of the longest run of a repeated non-space character
+func longestRun(text string) int {
+	longest, n := 0, 0
+	var prev rune
+	for _, r := range text {
+		if r == prev && !unicode.IsSpace(r) {
+			n += 1
+		} else {
+			n = 1
+		}
+		prev = r
+		if n > longest {
+			longest = n
+		}
+	}
+	return longest
+}
+
+// Reports whether a text of some length is written mostly in capitals
+func shouting(text string) bool {
+	letters, upper := 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters += 1
+			if unicode.IsUpper(r) {
+				upper += 1
+			}
+		}
+	}
+	return letters >= minShoutLetters && float64(upper)/float64(letters) > maxUpperShare
+}
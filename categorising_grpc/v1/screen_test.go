@@ -0,0 +1,222 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A classifier that always answers with a copy of the same response
+type fixedClassifier struct {
+	resp  *pb.GetCategoryResponse
+	calls int
+	// language of the last call
+	lang string
+}
+
+func (f *fixedClassifier) classify(_ context.Context, _, lang string) (*pb.GetCategoryResponse, error) {
+	f.calls += 1
+	f.lang = lang
+	resp := &pb.GetCategoryResponse{Category: f.resp.Category, Confidence: f.resp.Confidence}
+	for _, c := range f.resp.Candidates {
+		resp.Candidates = append(resp.Candidates, &pb.CategoryCandidate{Category: c.Category, Confidence: c.Confidence})
+	}
+	return resp, nil
+}
+
+func (f *fixedClassifier) close() error {
+	return nil
+}
+
+func TestScreeningClassifier_classify(t *testing.T) {
+	confident := &pb.GetCategoryResponse{
+		Category:   messagepb.MessageCategory_QUESTION,
+		Confidence: .8,
+		Candidates: []*pb.CategoryCandidate{
+			{Category: messagepb.MessageCategory_QUESTION, Confidence: .8},
+			{Category: messagepb.MessageCategory_COMPLAINT, Confidence: .2},
+		},
+	}
+	unsure := &pb.GetCategoryResponse{
+		Category:   messagepb.MessageCategory_QUESTION,
+		Confidence: .4,
+		Candidates: []*pb.CategoryCandidate{
+			{Category: messagepb.MessageCategory_QUESTION, Confidence: .4},
+			{Category: messagepb.MessageCategory_COMPLAINT, Confidence: .3},
+			{Category: messagepb.MessageCategory_FEEDBACK, Confidence: .3},
+		},
+	}
+
+	cases := []struct {
+		engine     *pb.GetCategoryResponse
+		text       string
+		expected   messagepb.MessageCategory
+		engineUsed bool
+	}{
+		{confident, "", messagepb.MessageCategory_GARBAGE, false},
+		{confident, "  \n ", messagepb.MessageCategory_GARBAGE, false},
+		{confident, "Visit https://a.example http://b.example www.c.example now", messagepb.MessageCategory_GARBAGE, false},
+		{confident, "CLICK HERE FOR FREE MONEY AT OUR CASINO", messagepb.MessageCategory_GARBAGE, false},
+		{confident, "win win win win win win win win big", messagepb.MessageCategory_GARBAGE, false},
+		{confident, "Where is my order? See https://bobsknobshop.example/orders/1", messagepb.MessageCategory_QUESTION, true},
+		{confident, "Nice weather today", messagepb.MessageCategory_QUESTION, true},
+		{unsure, "Nice weather today", messagepb.MessageCategory_OTHER, true},
+		{unsure, "Does this knob fit my door", messagepb.MessageCategory_QUESTION, true},
+	}
+	for i, c := range cases {
+		engine := &fixedClassifier{resp: c.engine}
+		sc, err := newScreeningClassifier(engine, "", "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		resp, err := sc.classify(context.Background(), c.text, "")
+		if err != nil {
+			t.Errorf("case %v: unexpected error %v", i, err)
+			continue
+		}
+		if resp.GetCategory() != c.expected {
+			t.Errorf("case %v: expected %v, got %v (%v)", i, c.expected, resp.GetCategory(), resp.GetSignals())
+		}
+		if (engine.calls > 0) != c.engineUsed {
+			t.Errorf("case %v: expected engine used %v, got %v calls", i, c.engineUsed, engine.calls)
+		}
+		sum := 0.
+		for _, cand := range resp.GetCandidates() {
+			sum += float64(cand.GetConfidence())
+		}
+		if sum > 1+1e-6 {
+			t.Errorf("case %v: expected confidences to add up to at most 1, got %v", i, sum)
+		}
+	}
+}
+
+func TestScreeningClassifier_language(t *testing.T) {
+	resp := &pb.GetCategoryResponse{Category: messagepb.MessageCategory_QUESTION, Confidence: 1}
+
+	cases := []struct {
+		text     string
+		lang     string
+		expected string
+	}{
+		{"Where is my order?", "", "en"},
+		{"Où est ma commande?", "", "fr"},
+		{"Waar is mijn bestelling?", "", "nl"},
+		{"Where is my order?", "nl", "nl"},
+		{"Knob", "", ""},
+	}
+	for i, c := range cases {
+		engine := &fixedClassifier{resp: resp}
+		sc, err := newScreeningClassifier(engine, "", "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		resp, err := sc.classify(context.Background(), c.text, c.lang)
+		if err != nil {
+			t.Errorf("case %v: unexpected error %v", i, err)
+			continue
+		}
+		if resp.GetLanguageCode() != c.expected {
+			t.Errorf("case %v: expected language %q, got %q", i, c.expected, resp.GetLanguageCode())
+		}
+		if engine.lang != c.expected {
+			t.Errorf("case %v: expected engine to get language %q, got %q", i, c.expected, engine.lang)
+		}
+	}
+}
+
+func TestAsOther(t *testing.T) {
+	resp := &pb.GetCategoryResponse{
+		Category:   messagepb.MessageCategory_QUESTION,
+		Confidence: .4,
+		Candidates: []*pb.CategoryCandidate{
+			{Category: messagepb.MessageCategory_QUESTION, Confidence: .4},
+			{Category: messagepb.MessageCategory_FEEDBACK, Confidence: .6},
+		},
+	}
+	asOther(resp)
+
+	if resp.GetCategory() != messagepb.MessageCategory_OTHER || math.Abs(float64(resp.GetConfidence())-.6) > 1e-6 {
+		t.Errorf("expected OTHER with confidence .6, got %v %v", resp.GetCategory(), resp.GetConfidence())
+	}
+	expected := []float64{.6, .16, .24}
+	for i, cand := range resp.GetCandidates() {
+		if math.Abs(float64(cand.GetConfidence())-expected[i]) > 1e-6 {
+			t.Errorf("case %v: expected %v, got %v", i, expected[i], cand.GetConfidence())
+		}
+	}
+}
+
+func TestScreeningClassifier_garbageScore(t *testing.T) {
+	sc, err := newScreeningClassifier(&fixedClassifier{}, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		text     string
+		expected []string
+	}{
+		{"", []string{"empty"}},
+		{"My knob broke, can I get a new one?", nil},
+		{"see www.example.com", []string{"links"}},
+		{"Noooooooooooo!", []string{"repeated-characters"}},
+		{"buy buy buy buy buy buy buy buy", []string{"repeated-words"}},
+		{"Earn bitcoin, work from home", []string{"phrase:bitcoin", "phrase:work from home"}},
+		{"WHERE IS MY ORDER, I PAID LAST WEEK", []string{"shouting"}},
+	}
+	for i, c := range cases {
+		links := len(linkPattern.FindAllString(c.text, -1))
+		words := strings.Fields(strings.ToLower(linkPattern.ReplaceAllString(c.text, " ")))
+		for j, w := range words {
+			words[j] = strings.Trim(w, ",.!?")
+		}
+		if c.text == "" {
+			words = nil
+		}
+		_, actual := sc.garbageScore(c.text, words, links)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestNewScreeningClassifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "categorising")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	phrases := filepath.Join(dir, "phrases.txt")
+	if err := ioutil.WriteFile(phrases, []byte("# spam\n\nCheap Knobs\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	topics := filepath.Join(dir, "topics.txt")
+	if err := ioutil.WriteFile(topics, []byte("weather\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := newScreeningClassifier(&fixedClassifier{}, phrases, topics)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(sc.phrases, []string{"cheap knobs"}) {
+		t.Errorf("expected phrases from file, got %v", sc.phrases)
+	}
+	if !sc.onTopic([]string{"weather"}) || sc.onTopic([]string{"knob"}) {
+		t.Errorf("expected topics from file, got %v", sc.topics)
+	}
+
+	if _, err := newScreeningClassifier(&fixedClassifier{}, filepath.Join(dir, "missing.txt"), ""); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"cloud.google.com/go/language/apiv1"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/api/option"
+	languagepb "google.golang.org/genproto/googleapis/cloud/language/v1"
+	"google.golang.org/grpc"
+	"log"
+	"math"
+)
+
+const (
+	// sentiment below this is negative
+	complaintThreshold = 0
+	// questions more negative than this are complaints phrased as questions
+	angryQuestionThreshold = -.5
+	// how fast confidence in a category drops with the distance of a score
+	// to the category's band
+	sentimentSpread = .2
+)
+
+// Languages supported by the sentiment analysis of the Natural Language API
+var sentimentLanguages = map[string]bool{
+	"de": true, "en": true, "es": true, "fr": true, "it": true, "ja": true, "ko": true, "pt": true, "zh": true,
+}
+
+// Categorises texts by their Cloud Natural Language sentiment score and the
+// question cues they contain.
+type sentimentClassifier struct {
+	client *language.Client
+}
+
+// Creates a classifier using the Natural Language API, or the stand-in at the
+// endpoint if given. The stand-in is reached without TLS or credentials.
+func newSentimentClassifier(ctx context.Context, endpoint string) (*sentimentClassifier, error) {
+	var opts []option.ClientOption
+	if endpoint != "" {
+		conn, err := grpc.DialContext(ctx, endpoint, grpc.WithInsecure())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithGRPCConn(conn))
+	}
+	client, err := language.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &sentimentClassifier{client: client}, nil
+}
+
+func (c sentimentClassifier) classify(ctx context.Context, text, lang string) (*pb.GetCategoryResponse, error) {
+	doc := &languagepb.Document{
+		Source: &languagepb.Document_Content{Content: text},
+		Type:   languagepb.Document_PLAIN_TEXT,
+	}
+	// leave other languages to the detection of the API
+	if sentimentLanguages[lang] {
+		doc.Language = lang
+	}
+	sentiment, err := c.client.AnalyzeSentiment(ctx, &languagepb.AnalyzeSentimentRequest{
+		Document:     doc,
+		EncodingType: languagepb.EncodingType_UTF8,
+	})
+	if err != nil {
+		return nil, err
+	}
+	score := sentiment.GetDocumentSentiment().GetScore()
+
+	log.Printf("INFO: message was scored with %v", score)
+
+	return sentimentResponse(text, lang, score, sentiment.GetDocumentSentiment().GetMagnitude()), nil
+}
+
+func (c sentimentClassifier) close() error {
+	return c.client.Close()
+}
+
+// Categorises a text given its sentiment. Without a clear winner, the text
+// is treated as a question, so that someone looks at it.
+func sentimentResponse(text, lang string, score, magnitude float32) *pb.GetCategoryResponse {
+	q, cues := questionScore(text, lang)
+	confidences := sentimentConfidences(score, q)
+	cat, ok := top(confidences)
+	if !ok {
+		cat = messagepb.MessageCategory_QUESTION
+	}
+
+	signals := &pb.CategorySignals{
+		SentimentScore:     score,
+		SentimentMagnitude: magnitude,
+		QuestionScore:      float32(q),
+		QuestionCues:       cues,
+	}
+	return newResponse(cat, confidences, signals)
+}
+
+// Estimates the confidence in each category from the sentiment score and
+// question score of a text. The question score is the confidence in QUESTION,
+// the rest is divided between COMPLAINT and FEEDBACK by sentiment: the side
+// of the threshold the score is on gets full weight, the other side less the
+// further away the score is. Strongly negative questions count as
+// complaints.
+func sentimentConfidences(score float32, question float64) map[messagepb.MessageCategory]float64 {
+	s := float64(score)
+	if s < angryQuestionThreshold {
+		question = 0
+	}
+	neg := bandWeight(s, math.Inf(-1), complaintThreshold)
+	pos := bandWeight(s, complaintThreshold, math.Inf(1))
+	return map[messagepb.MessageCategory]float64{
+		messagepb.MessageCategory_COMPLAINT: (1 - question) * neg / (neg + pos),
+		messagepb.MessageCategory_QUESTION:  question,
+		messagepb.MessageCategory_FEEDBACK:  (1 - question) * pos / (neg + pos),
+	}
+}
+
+func bandWeight(score, lo, hi float64) float64 {
+	d := 0.
+	if score < lo {
+		d = lo - score
+	} else if score > hi {
+		d = score - hi
+	}
+	return math.Exp(-d / sentimentSpread)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	messagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/categorising_grpc/v1/languagetest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+// Starts the stand-in for the Natural Language API and a server using it
+func newSentimentServer(t *testing.T) (*languagetest.Fake, server, func()) {
+	fake := languagetest.NewFake(nil)
+	addr, stop, err := languagetest.Serve(fake)
+	if err != nil {
+		t.Fatalf("could not start language service: %v", err)
+	}
+	c, err := newClassifier(context.Background(), config{engine: sentimentEngine, languageEndpoint: addr})
+	if err != nil {
+		stop()
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return fake, newServer(c, nil, 1), func() {
+		_ = c.close()
+		stop()
+	}
+}
+
+func TestSentimentClassifier_endToEnd(t *testing.T) {
+	fake, s, stop := newSentimentServer(t)
+	defer stop()
+
+	fake.Script("The knob arrived today.", complaintThreshold-.01, .1)
+	fake.Script("The knob arrived yesterday.", complaintThreshold+.01, .1)
+	fake.Script("Why is my knob broken again?", angryQuestionThreshold-.1, .9)
+	fake.Script("Why is my knob not turning?", angryQuestionThreshold+.1, .6)
+
+	cases := []struct {
+		text     string
+		lang     string
+		expected messagepb.MessageCategory
+		docLang  string
+	}{
+		{"The knob arrived today.", "", messagepb.MessageCategory_COMPLAINT, "en"},
+		{"The knob arrived yesterday.", "", messagepb.MessageCategory_FEEDBACK, "en"},
+		{"Why is my knob broken again?", "", messagepb.MessageCategory_COMPLAINT, "en"},
+		{"Why is my knob not turning?", "", messagepb.MessageCategory_QUESTION, "en"},
+		{"This knob is awesome, thanks!", "", messagepb.MessageCategory_FEEDBACK, "en"},
+		{"This knob is not good, it is terrible.", "", messagepb.MessageCategory_COMPLAINT, "en"},
+		{"Ça je n'aime pas.", "", messagepb.MessageCategory_COMPLAINT, "fr"},
+		{"Mijn knop is kapot.", "", messagepb.MessageCategory_COMPLAINT, ""},
+	}
+	for i, c := range cases {
+		resp, err := s.GetCategory(context.Background(), &pb.GetCategoryRequest{Text: c.text, LanguageCode: c.lang})
+		if err != nil {
+			t.Errorf("case %v: unexpected error: %v", i, err)
+			continue
+		}
+		if resp.GetCategory() != c.expected {
+			t.Errorf("case %v: expected %v, got %v (%v)", i, c.expected, resp.GetCategory(), resp.GetSignals())
+		}
+		if fake.Document.GetLanguage() != c.docLang {
+			t.Errorf("case %v: expected document language %q, got %q", i, c.docLang, fake.Document.GetLanguage())
+		}
+	}
+
+	calls := fake.Calls
+	if resp, _ := s.GetCategory(context.Background(), &pb.GetCategoryRequest{Text: " "}); resp.GetCategory() != messagepb.MessageCategory_GARBAGE {
+		t.Errorf("expected garbage, got %v", resp.GetCategory())
+	}
+	if fake.Calls != calls {
+		t.Errorf("expected garbage not to be sent to the language service")
+	}
+
+	fake.Errs = []error{status.Error(codes.Unavailable, "")}
+	if _, err := s.GetCategory(context.Background(), &pb.GetCategoryRequest{Text: "The knob arrived today."}); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected %v, got %v", codes.Unavailable, err)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/HayoVanLoon/protoworkflow/categorising_grpc/v1/bayes"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// The outcome of classifying samples with known categories
+type evaluation struct {
+	labels []string
+	// counts per actual category of the predicted categories
+	confusion map[string]map[string]int
+}
+
+func evaluate(m *bayes.Model, samples []sample) *evaluation {
+	e := &evaluation{confusion: make(map[string]map[string]int)}
+	seen := make(map[string]bool)
+	for _, l := range m.Labels() {
+		seen[l] = true
+	}
+
+	for _, s := range samples {
+		predicted, _ := m.Classify(s.Text)
+		if e.confusion[s.Category] == nil {
+			e.confusion[s.Category] = make(map[string]int)
+		}
+		e.confusion[s.Category][predicted] += 1
+		seen[s.Category] = true
+	}
+
+	for l := range seen {
+		e.labels = append(e.labels, l)
+	}
+	sort.Strings(e.labels)
+	return e
+}
+
+func (e *evaluation) total() int {
+	n := 0
+	for _, ps := range e.confusion {
+		for _, c := range ps {
+			n += c
+		}
+	}
+	return n
+}
+
+func (e *evaluation) accuracy() float64 {
+	correct := 0
+	for l, ps := range e.confusion {
+		correct += ps[l]
+	}
+	return ratio(correct, e.total())
+}
+
+// The fraction of samples predicted as the label that really have it
+func (e *evaluation) precision(l string) float64 {
+	predicted := 0
+	for _, ps := range e.confusion {
+		predicted += ps[l]
+	}
+	return ratio(e.confusion[l][l], predicted)
+}
+
+// The fraction of samples with the label that were predicted as such
+func (e *evaluation) recall(l string) float64 {
+	actual := 0
+	for _, c := range e.confusion[l] {
+		actual += c
+	}
+	return ratio(e.confusion[l][l], actual)
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// Writes the metrics per category and the confusion matrix
+func (e *evaluation) write(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(w, "category\tprecision\trecall\n")
+	for _, l := range e.labels {
+		fmt.Fprintf(w, "%s\t%.3f\t%.3f\n", l, e.precision(l), e.recall(l))
+	}
+	fmt.Fprintf(w, "accuracy\t%.3f\t\n\n", e.accuracy())
+
+	fmt.Fprintf(w, "actual \\ predicted")
+	for _, l := range e.labels {
+		fmt.Fprintf(w, "\t%s", l)
+	}
+	fmt.Fprintln(w)
+	for _, a := range e.labels {
+		fmt.Fprint(w, a)
+		for _, p := range e.labels {
+			fmt.Fprintf(w, "\t%v", e.confusion[a][p])
+		}
+		fmt.Fprintln(w)
+	}
+	_ = w.Flush()
+}
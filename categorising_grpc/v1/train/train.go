@@ -0,0 +1,257 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Command train fits a naive Bayes model for the local categoriser engine on
+// messages whose category has been confirmed by agents, reports how well it
+// does on held out messages and writes the model file.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/categorising_grpc/v1/bayes"
+	"github.com/HayoVanLoon/protoworkflow/commons/clients"
+	"github.com/HayoVanLoon/protoworkflow/commons/retry"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagekey"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"hash/fnv"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	defaultPort    = "8080"
+	storageService = "storage-service"
+	defaultLimit   = 10000
+	defaultHoldout = .2
+	fetchTimeout   = time.Minute
+)
+
+// A message text with the category it belongs to
+type sample struct {
+	Name     string `json:"name"`
+	Text     string `json:"text"`
+	Category string `json:"category"`
+	Language string `json:"language,omitempty"`
+}
+
+// Determines the category agents have confirmed for a message: the category
+// it was last moved to or, when includeDone is set, the category of a message
+// that was handled without being moved.
+func label(m *pb.CustomerMessage, includeDone bool) (pb.MessageCategory, bool) {
+	if ms := m.GetMoves(); len(ms) > 0 {
+		cat := ms[len(ms)-1].GetNewCategory()
+		return cat, cat != pb.MessageCategory_NONE
+	}
+	if includeDone && m.GetStatus() == pb.Status_DONE && m.GetCategory() != pb.MessageCategory_NONE {
+		return m.GetCategory(), true
+	}
+	return pb.MessageCategory_NONE, false
+}
+
+// Retrieves the labelled messages from storage
+func fetchSamples(ctx context.Context, client storagepb.StorageClient, limit int32, includeDone bool) ([]sample, error) {
+	store := workqueue.NewStore(client, retry.DefaultPolicy())
+	query := &storagepb.Key{IndexedValues: []*storagepb.Key_Part{{Key: "status", Value: storagekey.Wildcard}}}
+
+	msgs, _, err := store.Query(ctx, []*storagepb.Key{query}, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []sample
+	for _, m := range msgs {
+		if m.GetBody() == "" {
+			continue
+		}
+		if cat, ok := label(m, includeDone); ok {
+			samples = append(samples, sample{Name: m.GetName(), Text: m.GetBody(), Category: cat.String(), Language: m.GetLanguageCode()})
+		}
+	}
+	return samples, nil
+}
+
+// Keeps the samples in a language
+func inLanguage(samples []sample, lang string) []sample {
+	var kept []sample
+	for _, s := range samples {
+		if s.Language == lang {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// Reads samples from a file with one JSON object per line
+func readSamples(path string) ([]sample, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []sample
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for i := 1; sc.Scan(); i += 1 {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		s := sample{}
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("line %v: %v", i, err)
+		}
+		samples = append(samples, s)
+	}
+	return samples, sc.Err()
+}
+
+// Writes samples to a file with one JSON object per line
+func writeSamples(path string, samples []sample) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, s := range samples {
+		if err := enc.Encode(s); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Divides samples into a training and a test set. The set a sample ends up
+// in only depends on its name, so results are comparable between runs.
+func split(samples []sample, holdout float64) (train, test []sample) {
+	for _, s := range samples {
+		h := fnv.New32a()
+		if s.Name != "" {
+			_, _ = h.Write([]byte(s.Name))
+		} else {
+			_, _ = h.Write([]byte(s.Text))
+		}
+		if float64(h.Sum32()%1000) < holdout*1000 {
+			test = append(test, s)
+		} else {
+			train = append(train, s)
+		}
+	}
+	return
+}
+
+func fit(samples []sample, version string) *bayes.Model {
+	m := bayes.New()
+	m.Version = version
+	for _, s := range samples {
+		m.Add(s.Category, s.Text)
+	}
+	return m
+}
+
+// Saves the model through a temporary file, so a server watching the file
+// never loads a partially written model.
+func save(m *bayes.Model, path string) error {
+	tmp := path + ".tmp"
+	if err := m.Save(tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func dialStorage(target string) (storagepb.StorageClient, *grpc.ClientConn, error) {
+	conn, err := grpc.Dial(target, clients.DefaultDialOptions()...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return storagepb.NewStorageClient(conn), conn, nil
+}
+
+func main() {
+	var storageHost = flag.String("storage-host", storageService, "storage service")
+	var storagePort = flag.String("storage-port", defaultPort, "storage service port")
+	var input = flag.String("input", "", "read labelled messages from this file instead of storage")
+	var export = flag.String("export", "", "write the labelled messages to this file")
+	var output = flag.String("output", "model.json", "model file to write")
+	var holdout = flag.Float64("holdout", defaultHoldout, "fraction of messages held out for evaluation")
+	var includeDone = flag.Bool("include-done", true, "use the category of handled messages that were never moved")
+	var limit = flag.Int("limit", defaultLimit, "maximum number of messages to fetch")
+	var language = flag.String("language", "", "only train on messages in this language")
+	flag.Parse()
+
+	var samples []sample
+	var err error
+	if *input != "" {
+		samples, err = readSamples(*input)
+	} else {
+		client, conn, dialErr := dialStorage(*storageHost + ":" + *storagePort)
+		if dialErr != nil {
+			log.Fatalf("could not connect to storage: %v", dialErr)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+		samples, err = fetchSamples(ctx, client, int32(*limit), *includeDone)
+		cancel()
+		_ = conn.Close()
+	}
+	if err != nil {
+		log.Fatalf("could not read labelled messages: %v", err)
+	}
+	log.Printf("INFO: found %v labelled messages", len(samples))
+
+	if *export != "" {
+		if err := writeSamples(*export, samples); err != nil {
+			log.Fatalf("could not export labelled messages: %v", err)
+		}
+	}
+	if *language != "" {
+		samples = inLanguage(samples, *language)
+		log.Printf("INFO: %v labelled messages in %s", len(samples), *language)
+	}
+	if len(samples) == 0 {
+		log.Fatal("nothing to train on")
+	}
+
+	version := time.Now().UTC().Format(time.RFC3339)
+
+	train, test := split(samples, *holdout)
+	if len(test) > 0 && len(train) > 0 {
+		log.Printf("INFO: evaluating on %v of %v messages", len(test), len(samples))
+		evaluate(fit(train, version), test).write(os.Stdout)
+	} else {
+		log.Print("WARN: too few messages for evaluation")
+	}
+
+	// the final model learns from all messages, including the held out ones
+	if err := save(fit(samples, version), *output); err != nil {
+		log.Fatalf("could not write model: %v", err)
+	}
+	log.Printf("INFO: wrote model %s to %s", version, *output)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/categorising_grpc/v1/bayes"
+	"github.com/HayoVanLoon/protoworkflow/commons/retry"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLabel(t *testing.T) {
+	moved := []*pb.CategoryMove{
+		{OldCategory: pb.MessageCategory_QUESTION, NewCategory: pb.MessageCategory_COMPLAINT},
+		{OldCategory: pb.MessageCategory_COMPLAINT, NewCategory: pb.MessageCategory_FEEDBACK},
+	}
+	cases := []struct {
+		m           *pb.CustomerMessage
+		includeDone bool
+		expected    pb.MessageCategory
+		ok          bool
+	}{
+		{&pb.CustomerMessage{Category: pb.MessageCategory_FEEDBACK, Moves: moved}, false, pb.MessageCategory_FEEDBACK, true},
+		{&pb.CustomerMessage{Category: pb.MessageCategory_QUESTION, Status: pb.Status_DONE}, true, pb.MessageCategory_QUESTION, true},
+		{&pb.CustomerMessage{Category: pb.MessageCategory_QUESTION, Status: pb.Status_DONE}, false, pb.MessageCategory_NONE, false},
+		{&pb.CustomerMessage{Category: pb.MessageCategory_QUESTION, Status: pb.Status_TO_DO}, true, pb.MessageCategory_NONE, false},
+		{&pb.CustomerMessage{Category: pb.MessageCategory_NONE, Status: pb.Status_DONE}, true, pb.MessageCategory_NONE, false},
+	}
+	for i, c := range cases {
+		actual, ok := label(c.m, c.includeDone)
+		if actual != c.expected || ok != c.ok {
+			t.Errorf("case %v: expected %v %v, got %v %v", i, c.expected, c.ok, actual, ok)
+		}
+	}
+}
+
+func TestFetchSamples(t *testing.T) {
+	storage := storagetest.NewFake()
+	store := workqueue.NewStore(storage, retry.DefaultPolicy())
+	msgs := []*pb.CustomerMessage{
+		{Name: "a", Body: "it broke", Category: pb.MessageCategory_QUESTION, Status: pb.Status_TO_DO,
+			Moves: []*pb.CategoryMove{{NewCategory: pb.MessageCategory_COMPLAINT}}},
+		{Name: "b", Body: "merci", Category: pb.MessageCategory_FEEDBACK, Status: pb.Status_DONE, LanguageCode: "fr"},
+		{Name: "c", Body: "what?", Category: pb.MessageCategory_QUESTION, Status: pb.Status_TO_DO},
+		{Name: "d", Category: pb.MessageCategory_FEEDBACK, Status: pb.Status_DONE},
+	}
+	for _, m := range msgs {
+		if _, err := store.Create(context.Background(), m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples, err := fetchSamples(context.Background(), storage, 10, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual := make(map[string]string)
+	for _, s := range samples {
+		actual[s.Name] = s.Category
+	}
+	expected := map[string]string{"a": "COMPLAINT", "b": "FEEDBACK"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	if fr := inLanguage(samples, "fr"); len(fr) != 1 || fr[0].Name != "b" {
+		t.Errorf("expected only b in French, got %v", fr)
+	}
+}
+
+func TestReadSamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "train")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "samples.jsonl")
+	expected := []sample{{"a", "it broke", "COMPLAINT", ""}, {"b", "merci\nbien", "FEEDBACK", "fr"}}
+	if err := writeSamples(path, expected); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	actual, err := readSamples(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{}\nnot json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSamples(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	var samples []sample
+	for i := 0; i < 1000; i += 1 {
+		samples = append(samples, sample{Name: string(rune('a'+i%26)) + string(rune('a'+i/26)), Text: "x"})
+	}
+
+	train, test := split(samples, .2)
+	if len(train)+len(test) != len(samples) {
+		t.Errorf("expected %v samples, got %v", len(samples), len(train)+len(test))
+	}
+	if frac := float64(len(test)) / float64(len(samples)); math.Abs(frac-.2) > .05 {
+		t.Errorf("expected about 20%% held out, got %v", frac)
+	}
+
+	_, again := split(samples, .2)
+	if !reflect.DeepEqual(test, again) {
+		t.Errorf("expected the same split on every run")
+	}
+
+	if _, none := split(samples, 0); len(none) != 0 {
+		t.Errorf("expected nothing held out, got %v", len(none))
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	m := bayes.New()
+	m.Add("COMPLAINT", "broken broken refund")
+	m.Add("FEEDBACK", "great love")
+
+	e := evaluate(m, []sample{
+		{Text: "broken", Category: "COMPLAINT"},
+		{Text: "refund", Category: "COMPLAINT"},
+		{Text: "love", Category: "FEEDBACK"},
+		{Text: "broken love broken", Category: "FEEDBACK"},
+		{Text: "how", Category: "QUESTION"},
+	})
+
+	if expected := []string{"COMPLAINT", "FEEDBACK", "QUESTION"}; !reflect.DeepEqual(e.labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, e.labels)
+	}
+	cases := []struct {
+		actual   float64
+		expected float64
+	}{
+		{e.accuracy(), .6},
+		{e.precision("COMPLAINT"), 2. / 4},
+		{e.recall("COMPLAINT"), 1},
+		{e.precision("FEEDBACK"), 1},
+		{e.recall("FEEDBACK"), .5},
+		{e.precision("QUESTION"), 0},
+		{e.recall("QUESTION"), 0},
+	}
+	for i, c := range cases {
+		if math.Abs(c.actual-c.expected) > 1e-9 {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, c.actual)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	e.write(buf)
+	for _, s := range []string{"precision", "accuracy", "actual \\ predicted", "QUESTION"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected report to contain %q, got:\n%s", s, buf.String())
+		}
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package breaker protects callers from a failing service by failing fast
+// once the service keeps failing, and probing it again after a cool-down.
+package breaker
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"sync"
+	"time"
+)
+
+// The states of a circuit breaker
+type State int
+
+const (
+	// Calls pass through
+	Closed State = iota
+	// Calls are rejected
+	Open
+	// A single call is let through to probe the service
+	HalfOpen
+)
+
+func (st State) String() string {
+	switch st {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+const (
+	defaultThreshold = 5
+	defaultCoolDown  = 30 * time.Second
+)
+
+// Reports whether an error indicates the service itself is failing, rather
+// than the request being at fault.
+func Failure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// A Breaker trips after a number of consecutive failures.
+type Breaker struct {
+	name      string
+	threshold int
+	coolDown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// Creates a closed breaker that opens after threshold consecutive failures
+// and stays open for the cool-down period. Zero values select the defaults.
+func New(name string, threshold int, coolDown time.Duration) *Breaker {
+	if threshold <= 0 {
+		threshold = defaultThreshold
+	}
+	if coolDown <= 0 {
+		coolDown = defaultCoolDown
+	}
+	return &Breaker{name: name, threshold: threshold, coolDown: coolDown, now: time.Now}
+}
+
+// Provides the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState()
+}
+
+func (b *Breaker) currentState() State {
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.coolDown {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// A Ticket is handed out for every call the breaker allows; the outcome of
+// the call is recorded with it.
+type Ticket struct {
+	probe bool
+}
+
+// Reports whether a call may proceed. When half-open, only one probing call
+// is allowed at a time.
+func (b *Breaker) Allow() (Ticket, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case Closed:
+		return Ticket{}, true
+	case HalfOpen:
+		if b.probing {
+			return Ticket{}, false
+		}
+		b.probing = true
+		return Ticket{probe: true}, true
+	default:
+		return Ticket{}, false
+	}
+}
+
+// Records the outcome of a call that was allowed. Only the probe decides
+// whether an open circuit closes again; other calls finishing late are
+// ignored.
+func (b *Breaker) Record(t Ticket, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if t.probe {
+		b.probing = false
+	} else if b.state != Closed {
+		return
+	}
+
+	if !Failure(err) {
+		if b.state != Closed {
+			log.Printf("INFO: circuit to %s closed", b.name)
+		}
+		b.state = Closed
+		b.failures = 0
+		return
+	}
+
+	b.failures += 1
+	if t.probe || b.failures >= b.threshold {
+		if b.state != Open || t.probe {
+			log.Printf("WARN: circuit to %s opened after %v failures: %v", b.name, b.failures, err)
+		}
+		b.state = Open
+		b.openedAt = b.now()
+	}
+}
+
+// Hands back a ticket without an outcome, so that a new probe may be made.
+func (b *Breaker) Cancel(t Ticket) {
+	if t.probe {
+		b.mu.Lock()
+		b.probing = false
+		b.mu.Unlock()
+	}
+}
+
+// Performs the call unless the circuit is open, in which case it fails
+// immediately with Unavailable.
+func (b *Breaker) Do(ctx context.Context, fn func(context.Context) error) error {
+	t, ok := b.Allow()
+	if !ok {
+		return status.Errorf(codes.Unavailable, "circuit to %s is open", b.name)
+	}
+	err := fn(ctx)
+	if ctx.Err() == context.Canceled {
+		// the caller gave up; this says nothing about the service
+		b.Cancel(t)
+		return err
+	}
+	b.Record(t, err)
+	return err
+}
@@ -0,0 +1,128 @@
+package breaker
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestFailure(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{status.Error(codes.Unavailable, ""), true},
+		{status.Error(codes.DeadlineExceeded, ""), true},
+		{status.Error(codes.Internal, ""), true},
+		{errors.New("foo"), true},
+		{status.Error(codes.InvalidArgument, ""), false},
+		{status.Error(codes.NotFound, ""), false},
+	}
+	for i, c := range cases {
+		if actual := Failure(c.err); actual != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New("foo", 2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	unavailable := status.Error(codes.Unavailable, "")
+	invalid := status.Error(codes.InvalidArgument, "")
+
+	steps := []struct {
+		advance time.Duration
+		err     error
+		allowed bool
+		state   State
+	}{
+		{0, unavailable, true, Closed},
+		{0, nil, true, Closed},
+		{0, unavailable, true, Closed},
+		{0, invalid, true, Closed},
+		{0, unavailable, true, Closed},
+		{0, unavailable, true, Open},
+		{0, nil, false, Open},
+		{30 * time.Second, nil, false, Open},
+		{30 * time.Second, unavailable, true, Open},
+		{time.Minute, nil, true, Closed},
+		{0, unavailable, true, Closed},
+	}
+	for i, s := range steps {
+		now = now.Add(s.advance)
+		called := false
+		err := b.Do(context.Background(), func(context.Context) error {
+			called = true
+			return s.err
+		})
+		if called != s.allowed {
+			t.Errorf("step %v: expected call allowed %v, got %v", i, s.allowed, called)
+		}
+		if !called && status.Code(err) != codes.Unavailable {
+			t.Errorf("step %v: expected %v, got %v", i, codes.Unavailable, err)
+		}
+		if actual := b.State(); actual != s.state {
+			t.Errorf("step %v: expected state %v, got %v", i, s.state, actual)
+		}
+	}
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New("foo", 1, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Record(Ticket{}, status.Error(codes.Unavailable, ""))
+	now = now.Add(time.Minute)
+
+	if st := b.State(); st != HalfOpen {
+		t.Fatalf("expected %v, got %v", HalfOpen, st)
+	}
+	probe, ok := b.Allow()
+	if !ok {
+		t.Errorf("expected probe to be allowed")
+	}
+	if _, ok := b.Allow(); ok {
+		t.Errorf("expected only one probe at a time")
+	}
+
+	// a call allowed before the circuit opened finishes late
+	b.Record(Ticket{}, nil)
+	if st := b.State(); st != HalfOpen {
+		t.Errorf("expected late call to leave the breaker %v, got %v", HalfOpen, st)
+	}
+	if _, ok := b.Allow(); ok {
+		t.Errorf("expected late call to leave the probe in flight")
+	}
+
+	b.Record(probe, nil)
+	if st := b.State(); st != Closed {
+		t.Errorf("expected %v, got %v", Closed, st)
+	}
+}
+
+func TestBreaker_Cancel(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := New("foo", 1, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Record(Ticket{}, status.Error(codes.Unavailable, ""))
+	now = now.Add(time.Minute)
+
+	probe, _ := b.Allow()
+	b.Cancel(Ticket{})
+	if _, ok := b.Allow(); ok {
+		t.Errorf("expected only the probe to end probing")
+	}
+	b.Cancel(probe)
+	if _, ok := b.Allow(); !ok {
+		t.Errorf("expected a new probe after cancelling")
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package clients manages long-lived gRPC connections to downstream services.
+//
+// A connection is dialled once per service and shared by all callers; gRPC
+// connections are safe for concurrent use and reconnect on their own.
+package clients
+
+import (
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// servers drop clients that ping more often than every five minutes,
+	// or when there are no active streams
+	keepaliveTime    = 5 * time.Minute
+	keepaliveTimeout = 10 * time.Second
+)
+
+// Provides the dial options used for all connections
+func DefaultDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: false,
+		}),
+	}
+}
+
+// A Manager holds one connection per downstream service.
+type Manager struct {
+	mu      sync.RWMutex
+	targets map[string]string
+	opts    []grpc.DialOption
+	conns   map[string]*grpc.ClientConn
+	closed  bool
+}
+
+// Creates a manager for the given services, mapping service names to their
+// host:port targets. Without dial options, DefaultDialOptions are used.
+func NewManager(targets map[string]string, opts ...grpc.DialOption) *Manager {
+	if len(opts) == 0 {
+		opts = DefaultDialOptions()
+	}
+	return &Manager{
+		targets: targets,
+		opts:    opts,
+		conns:   make(map[string]*grpc.ClientConn),
+	}
+}
+
+// Provides the shared connection to a service, dialling it on first use.
+func (m *Manager) Conn(service string) (*grpc.ClientConn, error) {
+	m.mu.RLock()
+	conn, ok := m.conns[service]
+	closed := m.closed
+	m.mu.RUnlock()
+	if ok {
+		return conn, nil
+	} else if closed {
+		return nil, fmt.Errorf("client manager closed")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if conn, ok := m.conns[service]; ok {
+		return conn, nil
+	} else if m.closed {
+		return nil, fmt.Errorf("client manager closed")
+	}
+	target, ok := m.targets[service]
+	if !ok {
+		return nil, fmt.Errorf("unknown service %s", service)
+	}
+
+	conn, err := grpc.Dial(target, m.opts...)
+	if err != nil {
+		return nil, fmt.Errorf("did not connect to %s: %v", service, err)
+	}
+	m.conns[service] = conn
+	log.Printf("INFO: connected to %s on %s", service, target)
+
+	return conn, nil
+}
+
+// Closes all connections. The manager cannot be used afterwards.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	var err error
+	for service, conn := range m.conns {
+		if e := conn.Close(); e != nil {
+			log.Printf("WARN: error closing connection to %s: %v", service, e)
+			err = e
+		}
+	}
+	m.conns = make(map[string]*grpc.ClientConn)
+	return err
+}
@@ -0,0 +1,33 @@
+package clients
+
+import (
+	"testing"
+)
+
+func TestManager_Conn(t *testing.T) {
+	m := NewManager(map[string]string{"foo": "localhost:50051", "bar": "localhost:50052"})
+
+	foo, err := m.Conn("foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if again, _ := m.Conn("foo"); again != foo {
+		t.Errorf("expected connection to be reused")
+	}
+	if bar, _ := m.Conn("bar"); bar == foo {
+		t.Errorf("expected separate connection per service")
+	}
+	if _, err := m.Conn("baz"); err == nil {
+		t.Errorf("expected error for unknown service")
+	}
+
+	if err := m.Close(); err != nil {
+		t.Errorf("unexpected error closing: %v", err)
+	}
+	if _, err := m.Conn("foo"); err == nil {
+		t.Errorf("expected error after close")
+	}
+	if err := m.Close(); err != nil {
+		t.Errorf("expected closing twice to be harmless, got %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package measure reports the calls handled by a gRPC server to the
+// Measuring service.
+//
+// Calls are recorded by server interceptors and shipped asynchronously in
+// batches, so reporting does not hold up the calls themselves. When the
+// queue of unreported calls is full, new calls are dropped.
+package measure
+
+import (
+	"flag"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/measuring/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/clients"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = 5 * time.Second
+	defaultQueueSize     = 1000
+	reportTimeout        = 10 * time.Second
+)
+
+// Options tune what is recorded and how it is shipped.
+type Options struct {
+	// Fraction of the calls to record, between 0 and 1
+	SampleRate float64
+	// Whether to include the request and response of unary calls
+	Payloads bool
+	// Maximum number of calls per report
+	BatchSize int
+	// Maximum time a recorded call waits before being reported
+	FlushInterval time.Duration
+	// Maximum number of unreported calls
+	QueueSize int
+}
+
+// Provides options recording all calls, without payloads
+func DefaultOptions() Options {
+	return Options{
+		SampleRate:    1,
+		BatchSize:     defaultBatchSize,
+		FlushInterval: defaultFlushInterval,
+		QueueSize:     defaultQueueSize,
+	}
+}
+
+// A Recorder records calls and reports them to the Measuring service. A nil
+// Recorder records nothing.
+type Recorder struct {
+	client  pb.MeasuringClient
+	conn    *grpc.ClientConn
+	opts    Options
+	calls   chan *pb.CreateServiceCallRequest
+	done    chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	dropped int
+}
+
+// Creates a recorder reporting to the client and starts shipping.
+func NewRecorder(client pb.MeasuringClient, opts Options) *Recorder {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	r := &Recorder{
+		client: client,
+		opts:   opts,
+		calls:  make(chan *pb.CreateServiceCallRequest, opts.QueueSize),
+		done:   make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+// Creates a recorder reporting to the Measuring service at the host:port
+// target. Without a target, measuring is not configured and a nil Recorder
+// is returned.
+func Dial(target string, opts Options) (*Recorder, error) {
+	if target == "" {
+		return nil, nil
+	}
+	conn, err := grpc.Dial(target, clients.DefaultDialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	r := NewRecorder(pb.NewMeasuringClient(conn), opts)
+	r.conn = conn
+	log.Printf("INFO: reporting calls to %s", target)
+	return r, nil
+}
+
+// Registers the measuring flags on the command line. The returned function
+// dials the Measuring service once the flags have been parsed; without a
+// measuring host, calls are not measured.
+func RegisterFlags(defaultPort string) func() (*Recorder, error) {
+	host := flag.String("measuring-host", "", "measuring service; calls are not measured when empty")
+	port := flag.String("measuring-port", defaultPort, "measuring service port")
+	sampleRate := flag.Float64("measuring-sample-rate", 1, "fraction of calls to measure")
+	payloads := flag.Bool("measuring-payloads", false, "include requests and responses in measurements")
+
+	return func() (*Recorder, error) {
+		if *host == "" {
+			return nil, nil
+		}
+		opts := DefaultOptions()
+		opts.SampleRate = *sampleRate
+		opts.Payloads = *payloads
+		return Dial(*host+":"+*port, opts)
+	}
+}
+
+// Provides the server options installing the interceptors; none for a nil
+// Recorder.
+func (r *Recorder) ServerOptions() []grpc.ServerOption {
+	if r == nil {
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(r.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(r.StreamServerInterceptor()),
+	}
+}
+
+// Splits a full method name "/package.Service/Method" into its service and
+// method
+func splitMethod(fullMethod string) (string, string) {
+	s := strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+func (r *Recorder) sampled() bool {
+	return r != nil && (r.opts.SampleRate >= 1 || rand.Float64() < r.opts.SampleRate)
+}
+
+// Queues a call for reporting, or drops it when the queue is full
+func (r *Recorder) record(c *pb.CreateServiceCallRequest) {
+	select {
+	case r.calls <- c:
+	default:
+		r.mu.Lock()
+		r.dropped += 1
+		r.mu.Unlock()
+	}
+}
+
+func newCall(fullMethod string, start time.Time, err error) *pb.CreateServiceCallRequest {
+	service, method := splitMethod(fullMethod)
+	return &pb.CreateServiceCallRequest{
+		ServiceName:   service,
+		RpcName:       method,
+		StartTime:     start.UnixNano() / int64(time.Millisecond),
+		LatencyMicros: int64(time.Since(start) / time.Microsecond),
+		Code:          int32(status.Code(err)),
+	}
+}
+
+// Intercepts unary calls to record them
+func (r *Recorder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !r.sampled() {
+			return handler(ctx, req)
+		}
+
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		c := newCall(info.FullMethod, start, err)
+		if r.opts.Payloads {
+			if m, ok := req.(proto.Message); ok {
+				c.Request, _ = ptypes.MarshalAny(m)
+			}
+			if m, ok := resp.(proto.Message); ok && err == nil {
+				c.Response, _ = ptypes.MarshalAny(m)
+			}
+		}
+		r.record(c)
+		return resp, err
+	}
+}
+
+// Intercepts streaming calls to record them. Payloads of streams are not
+// recorded.
+func (r *Recorder) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !r.sampled() {
+			return handler(srv, ss)
+		}
+
+		start := time.Now()
+		err := handler(srv, ss)
+		r.record(newCall(info.FullMethod, start, err))
+		return err
+	}
+}
+
+// Reports a batch of calls
+func (r *Recorder) report(batch []*pb.CreateServiceCallRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+
+	_, err := r.client.CreateServiceCalls(ctx, &pb.CreateServiceCallsRequest{ServiceCalls: batch})
+	if err != nil {
+		log.Printf("WARN: could not report %d calls: %v", len(batch), err)
+	}
+
+	r.mu.Lock()
+	dropped := r.dropped
+	r.dropped = 0
+	r.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("WARN: dropped %d calls", dropped)
+	}
+}
+
+// Ships the recorded calls in batches, until the recorder is closed
+func (r *Recorder) run() {
+	defer r.wg.Done()
+	t := time.NewTicker(r.opts.FlushInterval)
+	defer t.Stop()
+
+	var batch []*pb.CreateServiceCallRequest
+	flush := func() {
+		if len(batch) > 0 {
+			r.report(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case c := <-r.calls:
+			batch = append(batch, c)
+			if len(batch) >= r.opts.BatchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		case <-r.done:
+			for {
+				select {
+				case c := <-r.calls:
+					batch = append(batch, c)
+					if len(batch) >= r.opts.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Reports the remaining calls and stops the recorder.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	close(r.done)
+	r.wg.Wait()
+	if r.conn != nil {
+		return r.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package measure
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/measuring/v1"
+	"github.com/golang/protobuf/ptypes/empty"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMeasuring struct {
+	pb.MeasuringClient
+	mu      sync.Mutex
+	batches [][]*pb.CreateServiceCallRequest
+}
+
+func (f *fakeMeasuring) CreateServiceCalls(ctx context.Context, r *pb.CreateServiceCallsRequest, _ ...grpc.CallOption) (*pb.CreateServiceCallsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, r.GetServiceCalls())
+	return &pb.CreateServiceCallsResponse{Recorded: int32(len(r.GetServiceCalls()))}, nil
+}
+
+func (f *fakeMeasuring) calls() []*pb.CreateServiceCallRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var cs []*pb.CreateServiceCallRequest
+	for _, b := range f.batches {
+		cs = append(cs, b...)
+	}
+	return cs
+}
+
+func TestSplitMethod(t *testing.T) {
+	cases := []struct {
+		fullMethod, service, method string
+	}{
+		{"/bobsknobshop.storage.v1.Storage/GetObject", "bobsknobshop.storage.v1.Storage", "GetObject"},
+		{"Storage", "Storage", ""},
+	}
+	for i, c := range cases {
+		if service, method := splitMethod(c.fullMethod); service != c.service || method != c.method {
+			t.Errorf("case %v: expected %s %s, got %s %s", i, c.service, c.method, service, method)
+		}
+	}
+}
+
+func TestRecorder_UnaryServerInterceptor(t *testing.T) {
+	f := &fakeMeasuring{}
+	opts := DefaultOptions()
+	opts.Payloads = true
+	r := NewRecorder(f, opts)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/bobsknobshop.storage.v1.Storage/GetObject"}
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &empty.Empty{}, nil
+	}
+	notFound := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "")
+	}
+	intercept := r.UnaryServerInterceptor()
+	if _, err := intercept(context.Background(), &empty.Empty{}, info, ok); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := intercept(context.Background(), &empty.Empty{}, info, notFound); status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v, got %v", codes.NotFound, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	calls := f.calls()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 calls, got %v", calls)
+	}
+	if calls[0].GetServiceName() != "bobsknobshop.storage.v1.Storage" || calls[0].GetRpcName() != "GetObject" {
+		t.Errorf("unexpected call %v", calls[0])
+	}
+	if calls[0].GetRequest() == nil || calls[0].GetResponse() == nil {
+		t.Errorf("expected payloads, got %v", calls[0])
+	}
+	if codes.Code(calls[1].GetCode()) != codes.NotFound || calls[1].GetResponse() != nil {
+		t.Errorf("unexpected call %v", calls[1])
+	}
+}
+
+func TestRecorder_Batches(t *testing.T) {
+	f := &fakeMeasuring{}
+	r := NewRecorder(f, Options{SampleRate: 1, BatchSize: 2, FlushInterval: time.Hour})
+	info := &grpc.StreamServerInfo{FullMethod: "/s/m"}
+	intercept := r.StreamServerInterceptor()
+	for i := 0; i < 5; i += 1 {
+		_ = intercept(nil, nil, info, func(interface{}, grpc.ServerStream) error { return nil })
+	}
+	_ = r.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.batches) != 3 || len(f.batches[0]) != 2 || len(f.batches[2]) != 1 {
+		t.Errorf("expected batches of 2, 2 and 1, got %v", f.batches)
+	}
+}
+
+func TestRecorder_Sampling(t *testing.T) {
+	f := &fakeMeasuring{}
+	r := NewRecorder(f, Options{SampleRate: 0})
+	info := &grpc.UnaryServerInfo{FullMethod: "/s/m"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	for i := 0; i < 10; i += 1 {
+		_, _ = r.UnaryServerInterceptor()(context.Background(), nil, info, handler)
+	}
+	_ = r.Close()
+	if calls := f.calls(); len(calls) != 0 {
+		t.Errorf("expected no calls recorded, got %v", calls)
+	}
+
+	var none *Recorder
+	if _, err := none.UnaryServerInterceptor()(context.Background(), nil, info, handler); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if none.ServerOptions() != nil || none.Close() != nil {
+		t.Errorf("expected a nil recorder to do nothing")
+	}
+	if r, err := Dial("", DefaultOptions()); r != nil || err != nil {
+		t.Errorf("expected no recorder without target, got %v, %v", r, err)
+	}
+}
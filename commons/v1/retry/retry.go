@@ -0,0 +1,115 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package retry retries gRPC calls that failed on transient errors, backing
+// off exponentially between attempts.
+package retry
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// A Policy describes how often and how patiently a call is retried.
+type Policy struct {
+	// Maximum number of attempts, including the first
+	MaxAttempts int
+	// Wait before the first retry
+	InitialBackoff time.Duration
+	// Upper bound on the wait between attempts
+	MaxBackoff time.Duration
+	// Growth factor of the wait after each attempt
+	Multiplier float64
+	// Fraction of the wait that is randomised, between 0 and 1
+	Jitter float64
+}
+
+// Provides the policy used between services
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    4,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Reports whether a call that failed with the error may succeed when tried
+// again.
+func Retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// Determines the wait before a retry; the first retry has number 1.
+func (p Policy) Backoff(retry int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < retry && d < float64(p.MaxBackoff); i += 1 {
+		d *= p.Multiplier
+	}
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// Converts a context error into the matching gRPC error
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.Canceled {
+		return status.Error(codes.Canceled, ctx.Err().Error())
+	}
+	return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+}
+
+// Calls fn until it succeeds, fails with an error that is not retryable,
+// runs out of attempts or the context is done. The error of the last
+// attempt is returned.
+func (p Policy) Do(ctx context.Context, fn func(context.Context) error) error {
+	var err error
+	for i := 0; i < p.MaxAttempts || i == 0; i += 1 {
+		if i > 0 {
+			t := time.NewTimer(p.Backoff(i))
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return contextError(ctx)
+			}
+		}
+
+		err = fn(ctx)
+		if err == nil || !Retryable(err) {
+			return err
+		} else if ctx.Err() != nil {
+			return contextError(ctx)
+		}
+		log.Printf("WARN: attempt %v failed: %v", i+1, err)
+	}
+	return err
+}
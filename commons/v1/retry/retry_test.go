@@ -0,0 +1,119 @@
+package retry
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{status.Error(codes.Unavailable, ""), true},
+		{status.Error(codes.DeadlineExceeded, ""), true},
+		{status.Error(codes.InvalidArgument, ""), false},
+		{status.Error(codes.NotFound, ""), false},
+		{status.Error(codes.Internal, ""), false},
+		{errors.New("foo"), false},
+		{nil, false},
+	}
+	for i, c := range cases {
+		if actual := Retryable(c.err); actual != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestPolicy_Backoff(t *testing.T) {
+	p := Policy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	cases := []struct {
+		retry    int
+		expected time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second},
+	}
+	for i, c := range cases {
+		if actual := p.Backoff(c.retry); actual != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i += 1 {
+		if d := p.Backoff(1); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("expected jittered backoff within 50ms-150ms, got %v", d)
+		}
+	}
+}
+
+func TestPolicy_Do(t *testing.T) {
+	p := Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 2}
+	unavailable := status.Error(codes.Unavailable, "")
+	invalid := status.Error(codes.InvalidArgument, "")
+
+	cases := []struct {
+		errs     []error
+		attempts int
+		expected codes.Code
+	}{
+		{[]error{nil}, 1, codes.OK},
+		{[]error{unavailable, nil}, 2, codes.OK},
+		{[]error{unavailable, unavailable, unavailable}, 3, codes.Unavailable},
+		{[]error{invalid}, 1, codes.InvalidArgument},
+		{[]error{unavailable, invalid}, 2, codes.InvalidArgument},
+	}
+	for i, c := range cases {
+		attempts := 0
+		err := p.Do(context.Background(), func(context.Context) error {
+			attempts += 1
+			return c.errs[attempts-1]
+		})
+		if attempts != c.attempts {
+			t.Errorf("case %v: expected %v attempts, got %v", i, c.attempts, attempts)
+		}
+		if status.Code(err) != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, err)
+		}
+	}
+}
+
+func TestPolicy_DoContext(t *testing.T) {
+	p := Policy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour, Multiplier: 2}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := p.Do(ctx, func(context.Context) error {
+		attempts += 1
+		return status.Error(codes.Unavailable, "")
+	})
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got %v", attempts)
+	}
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("expected %v, got %v", codes.Canceled, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	time.Sleep(2 * time.Millisecond)
+	err = p.Do(ctx, func(ctx context.Context) error {
+		return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", codes.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,34 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package storagekey helps build the keys of objects in the storage service.
+package storagekey
+
+import (
+	"strings"
+)
+
+// Matches any value in a storage query
+const Wildcard = "*"
+
+// Storage reserves '=', '~' and '*' in key values
+var escaper = strings.NewReplacer("%", "%25", "=", "%3D", "~", "%7E", "*", "%2A")
+
+// Escapes free text for use as an indexed value
+func IndexValue(s string) string {
+	return escaper.Replace(s)
+}
@@ -0,0 +1,23 @@
+package storagekey
+
+import (
+	"testing"
+)
+
+func TestIndexValue(t *testing.T) {
+	cases := []struct {
+		s        string
+		expected string
+	}{
+		{"", ""},
+		{"knobs", "knobs"},
+		{"a=b~c*d", "a%3Db%7Ec%2Ad"},
+		{"100%", "100%25"},
+		{"%3D", "%253D"},
+	}
+	for i, c := range cases {
+		if actual := IndexValue(c.s); actual != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
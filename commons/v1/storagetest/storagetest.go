@@ -0,0 +1,197 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package storagetest provides an in-memory storage service for tests. It
+// follows the semantics of the storage service: limits are ignored, results
+// come in no particular order and etags are derived from the data.
+package storagetest
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/golang/protobuf/ptypes/empty"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Matches any value in a query
+const wildcard = "*"
+
+type entry struct {
+	// the key as returned by the storage server
+	key *storagepb.Key
+	// the parts and indexed values queries are matched against
+	idx  []*storagepb.Key_Part
+	data []byte
+}
+
+func (e *entry) etag() string {
+	return etag(e.data)
+}
+
+// Derives the etag from the data, like the storage server does
+func etag(data []byte) string {
+	return fmt.Sprintf("%x", sha1.New().Sum(data))
+}
+
+// An in-memory storage server. Calls fail with the queued errors first.
+type Fake struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	// errors returned by the next calls
+	Errs []error
+	// number of calls made
+	Calls int
+	// deadline of the last call
+	Deadline time.Time
+}
+
+func NewFake(errs ...error) *Fake {
+	return &Fake{entries: make(map[string]*entry), Errs: errs}
+}
+
+// Registers the call and pops the next queued error
+func (f *Fake) call(ctx context.Context) error {
+	f.Calls += 1
+	f.Deadline, _ = ctx.Deadline()
+	if len(f.Errs) > 0 {
+		err := f.Errs[0]
+		f.Errs = f.Errs[1:]
+		return err
+	}
+	return nil
+}
+
+// Derives the object name from the key parts, like the storage server does
+func Name(k *storagepb.Key) string {
+	if k.GetName() != "" {
+		return k.GetName()
+	}
+	var ss []string
+	for _, p := range k.GetParts() {
+		ss = append(ss, p.GetKey()+"="+p.GetValue())
+	}
+	return strings.Join(ss, "~")
+}
+
+// Collects the parts and indexed values of a key
+func index(k *storagepb.Key) []*storagepb.Key_Part {
+	return append(append([]*storagepb.Key_Part{}, k.GetParts()...), k.GetIndexedValues()...)
+}
+
+func (f *Fake) CreateObject(ctx context.Context, r *storagepb.CreateObjectRequest, _ ...grpc.CallOption) (*storagepb.CreateObjectResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx); err != nil {
+		return nil, err
+	}
+
+	name := Name(r.GetKey())
+	if _, ok := f.entries[name]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "could not store %s", name)
+	}
+	key := &storagepb.Key{Name: name, Parts: r.GetKey().GetParts()}
+	e := &entry{key: key, idx: index(r.GetKey()), data: r.GetData()}
+	f.entries[name] = e
+	return &storagepb.CreateObjectResponse{Name: name, Etag: e.etag()}, nil
+}
+
+// Reports whether the entry satisfies all parts and indexed values of the
+// query
+func (e *entry) matches(q *storagepb.Key) bool {
+	if Name(q) == e.key.GetName() {
+		return true
+	} else if q.GetName() != "" {
+		return false
+	}
+	for _, qp := range index(q) {
+		found := false
+		for _, p := range e.idx {
+			found = found || p.GetKey() == qp.GetKey() && (qp.GetValue() == wildcard || qp.GetValue() == p.GetValue())
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Fake) GetObject(ctx context.Context, r *storagepb.GetObjectRequest, _ ...grpc.CallOption) (*storagepb.GetObjectResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, q := range r.GetKeys() {
+		if _, ok := f.entries[Name(q)]; !ok && len(index(q)) == 0 {
+			return nil, errors.New("empty query")
+		}
+	}
+
+	// like the storage server, the limit is not applied
+	resp := &storagepb.GetObjectResponse{}
+	for _, e := range f.entries {
+		for _, q := range r.GetKeys() {
+			if e.matches(q) {
+				resp.Entries = append(resp.Entries, &storagepb.GetObjectResponse_Entry{Key: e.key, Data: e.data, Etag: e.etag()})
+				break
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (f *Fake) MutateObject(ctx context.Context, r *storagepb.MutateObjectRequest, _ ...grpc.CallOption) (*storagepb.MutateObjectResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx); err != nil {
+		return nil, err
+	}
+
+	e, ok := f.entries[Name(r.GetOldKey())]
+	if !ok || e.etag() != r.GetOldEtag() {
+		return &storagepb.MutateObjectResponse{}, nil
+	}
+	e.idx = index(r.GetNewKey())
+	e.data = r.GetNewData()
+	return &storagepb.MutateObjectResponse{NewEtag: e.etag()}, nil
+}
+
+func (f *Fake) DeleteObject(ctx context.Context, r *storagepb.DeleteObjectRequest, _ ...grpc.CallOption) (*empty.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, k := range r.GetKeys() {
+		delete(f.entries, Name(k))
+	}
+	return &empty.Empty{}, nil
+}
+
+func (f *Fake) GetStats(ctx context.Context, r *storagepb.GetStatsRequest, _ ...grpc.CallOption) (*storagepb.GetStatsResponse, error) {
+	return &storagepb.GetStatsResponse{}, nil
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package ulid generates ULIDs: unique identifiers that sort by creation
+// time. A ULID is 26 characters long and consists of a 48-bit millisecond
+// timestamp followed by 80 random bits, encoded in Crockford's base32.
+package ulid
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	encoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	length   = 26
+	maxTime  = 1<<48 - 1
+)
+
+// A Generator creates ULIDs that are strictly increasing, also when several
+// are created within the same millisecond.
+type Generator struct {
+	mu       sync.Mutex
+	entropy  io.Reader
+	lastTime uint64
+	last     [10]byte
+}
+
+// Creates a generator that draws its random bits from the entropy source.
+func NewGenerator(entropy io.Reader) *Generator {
+	return &Generator{entropy: entropy}
+}
+
+var defaultGenerator = NewGenerator(rand.Reader)
+
+// Creates a ULID for the current time.
+func New() string {
+	return defaultGenerator.At(time.Now())
+}
+
+// Creates a ULID for the given time. Within the same millisecond, the random
+// part of the previous ULID is incremented to preserve the order.
+func (g *Generator) At(t time.Time) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	if ms <= g.lastTime && increment(&g.last) {
+		ms = g.lastTime
+	} else {
+		if _, err := io.ReadFull(g.entropy, g.last[:]); err != nil {
+			panic(fmt.Sprintf("could not read entropy: %v", err))
+		}
+		if ms < g.lastTime {
+			ms = g.lastTime
+		}
+	}
+	g.lastTime = ms
+
+	var id [16]byte
+	for i := 0; i < 6; i += 1 {
+		id[i] = byte(ms >> uint(40-8*i))
+	}
+	copy(id[6:], g.last[:])
+	return encode(id)
+}
+
+// Adds one to the big-endian number, reporting false on overflow
+func increment(bs *[10]byte) bool {
+	for i := len(bs) - 1; i >= 0; i -= 1 {
+		bs[i] += 1
+		if bs[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Encodes the 128 bits as 26 base32 digits, five bits per digit, with the
+// first digit holding only the top three bits.
+func encode(id [16]byte) string {
+	var sb strings.Builder
+	for i := 0; i < length; i += 1 {
+		// bit offset of the digit, counting from the (virtual) 130-bit start
+		offset := i*5 - 2
+		v := 0
+		for b := 0; b < 5; b += 1 {
+			bit := offset + b
+			v <<= 1
+			if bit >= 0 && id[bit/8]&(0x80>>uint(bit%8)) != 0 {
+				v |= 1
+			}
+		}
+		sb.WriteByte(encoding[v])
+	}
+	return sb.String()
+}
+
+// Extracts the creation time from a ULID.
+func Time(id string) (time.Time, error) {
+	if len(id) != length {
+		return time.Time{}, fmt.Errorf("invalid ulid length %v", len(id))
+	}
+	var ms uint64
+	for i := 0; i < 10; i += 1 {
+		v := strings.IndexByte(encoding, id[i])
+		if v < 0 {
+			return time.Time{}, fmt.Errorf("invalid ulid character '%c'", id[i])
+		}
+		ms = ms<<5 | uint64(v)
+	}
+	if ms > maxTime {
+		return time.Time{}, fmt.Errorf("invalid ulid timestamp")
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond)), nil
+}
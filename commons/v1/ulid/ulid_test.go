@@ -0,0 +1,73 @@
+package ulid
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	cases := []struct {
+		id       [16]byte
+		expected string
+	}{
+		{[16]byte{}, "00000000000000000000000000"},
+		{
+			[16]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			"7ZZZZZZZZZZZZZZZZZZZZZZZZZ",
+		},
+		{[16]byte{15: 1}, "00000000000000000000000001"},
+		{[16]byte{15: 32}, "00000000000000000000000010"},
+	}
+	for i, c := range cases {
+		if actual := encode(c.id); actual != c.expected {
+			t.Errorf("case %v: expected %s, got %s", i, c.expected, actual)
+		}
+	}
+}
+
+func TestGenerator_At(t *testing.T) {
+	g := NewGenerator(bytes.NewReader(make([]byte, 100)))
+	now := time.Unix(1561900000, 123*int64(time.Millisecond))
+
+	first := g.At(now)
+	if first != "01DEM7X8VV0000000000000000" {
+		t.Errorf("unexpected ulid %s", first)
+	}
+	if ts, err := Time(first); err != nil || !ts.Equal(now) {
+		t.Errorf("expected time %v, got %v (%v)", now, ts, err)
+	}
+
+	second := g.At(now)
+	if second <= first {
+		t.Errorf("expected %s to sort after %s", second, first)
+	}
+	earlier := g.At(now.Add(-time.Second))
+	if earlier <= second {
+		t.Errorf("expected %s to sort after %s despite the clock going back", earlier, second)
+	}
+}
+
+func TestNew(t *testing.T) {
+	seen := make(map[string]bool)
+	prev := ""
+	for i := 0; i < 1000; i += 1 {
+		id := New()
+		if len(id) != length {
+			t.Fatalf("expected length %v, got %s", length, id)
+		}
+		if seen[id] || id <= prev {
+			t.Fatalf("expected unique increasing ulids, got %s after %s", id, prev)
+		}
+		seen[id] = true
+		prev = id
+	}
+}
+
+func TestTime(t *testing.T) {
+	for _, id := range []string{"", "01DES2HJBV", "01DES2HJBU0000000000000000", "8ZZZZZZZZZ0000000000000000"} {
+		if _, err := Time(id); err == nil {
+			t.Errorf("expected error for '%s'", id)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package workqueue
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// Current time in milliseconds, the resolution used for message timestamps
+func NowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Checks that an agent is named; a lease without a holder could be taken over
+// by anyone else leaving it out.
+func ValidateAgent(agent string) error {
+	if agent == "" {
+		return status.Error(codes.InvalidArgument, "missing agent")
+	}
+	return nil
+}
+
+// Reports whether a message in process may be returned to its queue: its
+// lease has run out, or it has no lease or no holder to renew it
+func Reclaimable(m *pb.CustomerMessage, now int64) bool {
+	return m.GetStatus() == pb.Status_IN_PROCESS &&
+		(m.GetLease().GetHolder() == "" || m.GetLease().GetExpireTime() <= now)
+}
+
+// Reports whether a message is in process under a lease held by the agent
+func LeaseHeldBy(m *pb.CustomerMessage, agent string, now int64) bool {
+	return m.GetStatus() == pb.Status_IN_PROCESS &&
+		m.GetLease().GetHolder() == agent &&
+		m.GetLease().GetExpireTime() > now
+}
+
+// Creates a copy of the message, claimed by the agent
+func WithLease(m *pb.CustomerMessage, agent string, d time.Duration) *pb.CustomerMessage {
+	newM := proto.Clone(m).(*pb.CustomerMessage)
+	newM.Status = pb.Status_IN_PROCESS
+	newM.Lease = &pb.Lease{
+		Holder:     agent,
+		ExpireTime: NowMillis() + int64(d/time.Millisecond),
+	}
+	return newM
+}
+
+// Creates a copy of the message, returned to the queue
+func WithoutLease(m *pb.CustomerMessage) *pb.CustomerMessage {
+	newM := proto.Clone(m).(*pb.CustomerMessage)
+	newM.Status = pb.Status_TO_DO
+	newM.Lease = nil
+	return newM
+}
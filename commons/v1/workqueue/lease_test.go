@@ -0,0 +1,49 @@
+package workqueue
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"testing"
+	"time"
+)
+
+func TestLeases(t *testing.T) {
+	now := NowMillis()
+	cases := []struct {
+		m           *pb.CustomerMessage
+		reclaimable bool
+		heldBy      bool
+	}{
+		{&pb.CustomerMessage{Status: pb.Status_TO_DO}, false, false},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS}, true, false},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS, Lease: &pb.Lease{ExpireTime: now + 1000}}, true, false},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS, Lease: &pb.Lease{Holder: "alice", ExpireTime: now + 1000}}, false, true},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS, Lease: &pb.Lease{Holder: "bob", ExpireTime: now + 1000}}, false, false},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS, Lease: &pb.Lease{Holder: "alice", ExpireTime: now}}, true, false},
+		{&pb.CustomerMessage{Status: pb.Status_DONE, Lease: &pb.Lease{Holder: "alice", ExpireTime: now}}, false, false},
+	}
+	for i, c := range cases {
+		if actual := Reclaimable(c.m, now); actual != c.reclaimable {
+			t.Errorf("case %v: expected reclaimable %v, got %v", i, c.reclaimable, actual)
+		}
+		if actual := LeaseHeldBy(c.m, "alice", now); actual != c.heldBy {
+			t.Errorf("case %v: expected held %v, got %v", i, c.heldBy, actual)
+		}
+	}
+}
+
+func TestWithLease(t *testing.T) {
+	m := &pb.CustomerMessage{Name: "foo", Status: pb.Status_TO_DO}
+
+	leased := WithLease(m, "alice", time.Minute)
+	if m.GetLease() != nil || m.GetStatus() != pb.Status_TO_DO {
+		t.Errorf("original message was modified")
+	}
+	if !LeaseHeldBy(leased, "alice", NowMillis()) {
+		t.Errorf("expected lease held by alice, got %v", leased.GetLease())
+	}
+
+	released := WithoutLease(leased)
+	if released.GetLease() != nil || released.GetStatus() != pb.Status_TO_DO {
+		t.Errorf("expected released message, got %v", released)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package workqueue
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultLeaseDuration      = 5 * time.Minute
+	DefaultLeaseCheckInterval = 30 * time.Second
+
+	// the most messages tried per claim attempt
+	claimLimit       = 10
+	maxClaimAttempts = 3
+)
+
+// A Queue hands out the open messages of one category to agents.
+type Queue struct {
+	store         *Store
+	category      pb.MessageCategory
+	leaseDuration time.Duration
+}
+
+// Creates the queue of a category; claims last for the lease duration.
+func NewQueue(store *Store, category pb.MessageCategory, leaseDuration time.Duration) *Queue {
+	return &Queue{store, category, leaseDuration}
+}
+
+// Provides the category of the queue
+func (q *Queue) Category() pb.MessageCategory {
+	return q.category
+}
+
+// Retrieves the messages in the languages that can be claimed: those still to
+// do and those whose lease has expired. The priority buckets are queried most
+// urgent first until enough messages are found; within a bucket, the most
+// urgent come first, the oldest first among equals.
+func (q *Queue) claimable(ctx context.Context, languages []string) ([]*pb.CustomerMessage, []string, error) {
+	var msgs []*pb.CustomerMessage
+	var etags []string
+	now := NowMillis()
+	sts := []pb.Status{pb.Status_TO_DO, pb.Status_IN_PROCESS}
+	for b := PriorityBucket(MaxPriority); b >= 0 && len(msgs) < claimLimit; b -= 1 {
+		found, foundEtags, err := q.store.ByPriority(ctx, q.category, b, sts, 0, languages...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var bucket byUrgency
+		for i, m := range found {
+			if m.GetStatus() == pb.Status_TO_DO || Reclaimable(m, now) {
+				bucket.msgs = append(bucket.msgs, m)
+				bucket.etags = append(bucket.etags, foundEtags[i])
+			}
+		}
+		sort.Sort(bucket)
+		msgs, etags = append(msgs, bucket.msgs...), append(etags, bucket.etags...)
+	}
+
+	if len(msgs) > claimLimit {
+		msgs, etags = msgs[:claimLimit], etags[:claimLimit]
+	}
+	return msgs, etags, nil
+}
+
+// Orders messages and their etags by descending priority, then by age
+type byUrgency struct {
+	msgs  []*pb.CustomerMessage
+	etags []string
+}
+
+func (b byUrgency) Len() int {
+	return len(b.msgs)
+}
+
+func (b byUrgency) Less(i, j int) bool {
+	if b.msgs[i].GetPriority() != b.msgs[j].GetPriority() {
+		return b.msgs[i].GetPriority() > b.msgs[j].GetPriority()
+	}
+	return b.msgs[i].GetTimestamp() < b.msgs[j].GetTimestamp()
+}
+
+func (b byUrgency) Swap(i, j int) {
+	b.msgs[i], b.msgs[j] = b.msgs[j], b.msgs[i]
+	b.etags[i], b.etags[j] = b.etags[j], b.etags[i]
+}
+
+// Claims the first message that is not claimed concurrently by someone else.
+func (q *Queue) claimFirst(ctx context.Context, msgs []*pb.CustomerMessage, etags []string, agent string) (*pb.CustomerMessage, error) {
+	for i, m := range msgs {
+		newM := WithLease(m, agent, q.leaseDuration)
+		newEtag, err := q.store.Mutate(ctx, m, newM, etags[i])
+		if err != nil {
+			return nil, err
+		}
+		if newEtag != "" {
+			return newM, nil
+		}
+	}
+	return nil, nil
+}
+
+// Claims the next open message on behalf of an agent, limited to the given
+// languages if any. Fails with NotFound when there is none.
+func (q *Queue) Claim(ctx context.Context, agent string, languages ...string) (*pb.CustomerMessage, error) {
+	if err := ValidateAgent(agent); err != nil {
+		return nil, err
+	}
+	for i := 0; i < maxClaimAttempts; i += 1 {
+		msgs, etags, err := q.claimable(ctx, languages)
+		if err != nil {
+			log.Printf("WARN: error retrieving messages: %s", err)
+			return nil, err
+		}
+		if len(msgs) == 0 {
+			break
+		}
+
+		m, err := q.claimFirst(ctx, msgs, etags, agent)
+		if err != nil {
+			log.Printf("WARN: error claiming %s: %s", q.category, err)
+			return nil, err
+		}
+		if m != nil {
+			log.Printf("DEBUG: %s claimed by '%s'", m.GetName(), agent)
+			return m, nil
+		}
+	}
+
+	return nil, status.Errorf(codes.NotFound, "no open messages in %s", q.category)
+}
+
+// Checks that a leased message belongs to the queue
+func (q *Queue) inQueue(m *pb.CustomerMessage) error {
+	if m.GetCategory() != q.category {
+		return status.Errorf(codes.NotFound, "%s is not in %s", m.GetName(), q.category)
+	}
+	return nil
+}
+
+// Extends the lease of an agent on a message of the queue by the lease
+// duration.
+func (q *Queue) Renew(ctx context.Context, name, agent string) (*pb.CustomerMessage, error) {
+	return q.store.UpdateLeased(ctx, name, agent, func(m *pb.CustomerMessage) (*pb.CustomerMessage, error) {
+		if err := q.inQueue(m); err != nil {
+			return nil, err
+		}
+		return WithLease(m, agent, q.leaseDuration), nil
+	})
+}
+
+// Hands a message claimed by an agent back to the queue.
+func (q *Queue) Release(ctx context.Context, name, agent string) (*pb.CustomerMessage, error) {
+	return q.store.UpdateLeased(ctx, name, agent, func(m *pb.CustomerMessage) (*pb.CustomerMessage, error) {
+		if err := q.inQueue(m); err != nil {
+			return nil, err
+		}
+		return WithoutLease(m), nil
+	})
+}
+
+// Returns messages in process with expired leases, or without a lease, to the
+// queue. Returns the number of messages released.
+func (q *Queue) ReleaseExpired(ctx context.Context) int {
+	msgs, etags, err := q.store.ByStatus(ctx, q.category, pb.Status_IN_PROCESS, 0)
+	if err != nil {
+		log.Printf("WARN: error retrieving messages: %s", err)
+		return 0
+	}
+
+	released := 0
+	now := NowMillis()
+	for i, m := range msgs {
+		if Reclaimable(m, now) {
+			if newEtag, err := q.store.Mutate(ctx, m, WithoutLease(m), etags[i]); err == nil && newEtag != "" {
+				log.Printf("INFO: released %s, leased by '%s'", m.GetName(), m.GetLease().GetHolder())
+				released += 1
+			}
+		}
+	}
+	return released
+}
+
+// Periodically returns messages with expired leases in the queues to their
+// queue.
+func Reap(interval time.Duration, queues ...*Queue) {
+	for range time.Tick(interval) {
+		for _, q := range queues {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			q.ReleaseExpired(ctx)
+			cancel()
+		}
+	}
+}
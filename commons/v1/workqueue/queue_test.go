@@ -0,0 +1,265 @@
+package workqueue
+
+import (
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/retry"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+var testPolicy = retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 2}
+
+func TestQueue_Claim(t *testing.T) {
+	store := NewStore(storagetest.NewFake(), testPolicy)
+	ctx := context.Background()
+	for _, m := range []*pb.CustomerMessage{
+		{Name: "q1", Category: pb.MessageCategory_QUESTION, Status: pb.Status_TO_DO},
+		{Name: "c1", Category: pb.MessageCategory_COMPLAINT, Status: pb.Status_TO_DO},
+		{Name: "q2", Category: pb.MessageCategory_QUESTION, Status: pb.Status_DONE},
+	} {
+		if _, err := store.Create(ctx, m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	q := NewQueue(store, pb.MessageCategory_QUESTION, time.Minute)
+	if _, err := q.Claim(ctx, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v for a missing agent, got %v", codes.InvalidArgument, err)
+	}
+	m, err := q.Claim(ctx, "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.GetName() != "q1" || !LeaseHeldBy(m, "alice", NowMillis()) {
+		t.Errorf("expected q1 claimed by alice, got %v", m)
+	}
+
+	if _, err := q.Claim(ctx, "bob"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v, got %v", codes.NotFound, err)
+	}
+
+	stored, _, err := store.Get(ctx, "q1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored.GetLease().GetHolder() != "alice" {
+		t.Errorf("expected stored lease held by alice, got %v", stored.GetLease())
+	}
+}
+
+func TestQueue_ClaimLanguages(t *testing.T) {
+	store := NewStore(storagetest.NewFake(), testPolicy)
+	ctx := context.Background()
+	for _, m := range []*pb.CustomerMessage{
+		{Name: "q1", Category: pb.MessageCategory_QUESTION, Status: pb.Status_TO_DO, LanguageCode: "fr"},
+		{Name: "q2", Category: pb.MessageCategory_QUESTION, Status: pb.Status_TO_DO, LanguageCode: "nl"},
+		{Name: "q3", Category: pb.MessageCategory_QUESTION, Status: pb.Status_TO_DO, LanguageCode: "en"},
+	} {
+		if _, err := store.Create(ctx, m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	q := NewQueue(store, pb.MessageCategory_QUESTION, time.Minute)
+	cases := []struct {
+		languages []string
+		expected  string
+	}{
+		{[]string{"nl"}, "q2"},
+		{[]string{"de", "en"}, "q3"},
+		{[]string{"nl", "en"}, ""},
+		{nil, "q1"},
+		{nil, ""},
+	}
+	for i, c := range cases {
+		m, err := q.Claim(ctx, "alice", c.languages...)
+		if c.expected == "" {
+			if status.Code(err) != codes.NotFound {
+				t.Errorf("case %v: expected %v, got %v", i, codes.NotFound, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %v: unexpected error: %v", i, err)
+		} else if m.GetName() != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, m.GetName())
+		}
+	}
+}
+
+func TestQueue_ClaimPriority(t *testing.T) {
+	store := NewStore(storagetest.NewFake(), testPolicy)
+	ctx := context.Background()
+	msgs := []*pb.CustomerMessage{
+		{Name: "urgent-new", Timestamp: 5000, Priority: 80},
+		{Name: "urgent-old", Timestamp: 3000, Priority: 80},
+		{Name: "normal", Timestamp: 1000, Priority: 40},
+		WithLease(&pb.CustomerMessage{Name: "expired", Timestamp: 4000, Priority: 80}, "bob", -time.Minute),
+	}
+	// more than are tried per claim attempt
+	for i := 0; i < claimLimit; i += 1 {
+		msgs = append(msgs, &pb.CustomerMessage{Name: fmt.Sprintf("low%d", i), Timestamp: int64(i)})
+	}
+	for _, m := range msgs {
+		m.Category = pb.MessageCategory_COMPLAINT
+		if _, err := store.Create(ctx, m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	q := NewQueue(store, pb.MessageCategory_COMPLAINT, time.Minute)
+	for i, expected := range []string{"urgent-old", "expired", "urgent-new", "normal", "low0", "low1"} {
+		m, err := q.Claim(ctx, "alice")
+		if err != nil {
+			t.Fatalf("case %v: unexpected error: %v", i, err)
+		}
+		if m.GetName() != expected {
+			t.Errorf("case %v: expected %v, got %v", i, expected, m.GetName())
+		}
+	}
+}
+
+func TestQueue_ClaimBuckets(t *testing.T) {
+	storage := storagetest.NewFake()
+	store := NewStore(storage, testPolicy)
+	ctx := context.Background()
+	msgs := []*pb.CustomerMessage{{Name: "low", Timestamp: 0}}
+	for i := 0; i < claimLimit; i += 1 {
+		msgs = append(msgs, &pb.CustomerMessage{Name: fmt.Sprintf("urgent%d", i), Timestamp: int64(i + 1), Priority: 100})
+	}
+	for _, m := range msgs {
+		m.Category = pb.MessageCategory_QUESTION
+		if _, err := store.Create(ctx, m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	q := NewQueue(store, pb.MessageCategory_QUESTION, time.Minute)
+	cases := []struct {
+		expected string
+		calls    int32
+	}{
+		// the top bucket holds enough candidates: one query and one claim
+		{"urgent0", 1 + 1},
+		// one candidate short: every bucket is queried
+		{"urgent1", PriorityBucket(MaxPriority) + 1 + 1},
+	}
+	for i, c := range cases {
+		before := storage.Calls
+		m, err := q.Claim(ctx, "alice")
+		if err != nil {
+			t.Fatalf("case %v: unexpected error: %v", i, err)
+		}
+		if m.GetName() != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, m.GetName())
+		}
+		if calls := int32(storage.Calls - before); calls != c.calls {
+			t.Errorf("case %v: expected %v storage calls, got %v", i, c.calls, calls)
+		}
+	}
+}
+
+func TestQueue_RenewRelease(t *testing.T) {
+	store := NewStore(storagetest.NewFake(), testPolicy)
+	ctx := context.Background()
+	for _, m := range []*pb.CustomerMessage{
+		{Name: "q1", Category: pb.MessageCategory_QUESTION},
+		{Name: "c1", Category: pb.MessageCategory_COMPLAINT},
+	} {
+		if _, err := store.Create(ctx, WithLease(m, "alice", time.Minute)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	q := NewQueue(store, pb.MessageCategory_QUESTION, time.Hour)
+	m, err := q.Renew(ctx, "q1", "alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.GetLease().GetExpireTime() < NowMillis()+int64(time.Minute/time.Millisecond) {
+		t.Errorf("expected lease to be extended by an hour, got %v", m.GetLease())
+	}
+
+	cases := []struct {
+		name     string
+		agent    string
+		expected codes.Code
+	}{
+		{"q1", "bob", codes.FailedPrecondition},
+		{"q1", "", codes.InvalidArgument},
+		{"c1", "alice", codes.NotFound},
+		{"x1", "alice", codes.NotFound},
+		{"q1", "alice", codes.OK},
+		{"q1", "alice", codes.FailedPrecondition},
+	}
+	for i, c := range cases {
+		m, err := q.Release(ctx, c.name, c.agent)
+		if status.Code(err) != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, err)
+		}
+		if err == nil && (m.GetStatus() != pb.Status_TO_DO || m.GetLease() != nil) {
+			t.Errorf("case %v: expected message back in the queue, got %v", i, m)
+		}
+	}
+
+	if _, err := q.Renew(ctx, "q1", "alice"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected no renewal after release, got %v", err)
+	}
+	if m, err := q.Claim(ctx, "bob"); err != nil || m.GetName() != "q1" {
+		t.Errorf("expected released q1 to be claimable, got %v, %v", m, err)
+	}
+}
+
+func TestQueue_ReleaseExpired(t *testing.T) {
+	store := NewStore(storagetest.NewFake(), testPolicy)
+	ctx := context.Background()
+	q := NewQueue(store, pb.MessageCategory_FEEDBACK, -time.Minute)
+
+	if _, err := store.Create(ctx, &pb.CustomerMessage{Name: "f1", Category: pb.MessageCategory_FEEDBACK, Status: pb.Status_TO_DO}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := q.Claim(ctx, "alice"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if released := q.ReleaseExpired(ctx); released != 1 {
+		t.Errorf("expected 1 released message, got %v", released)
+	}
+	m, _, _ := store.Get(ctx, "f1")
+	if m.GetStatus() != pb.Status_TO_DO || m.GetLease() != nil {
+		t.Errorf("expected message back in queue, got %v", m)
+	}
+}
+
+func TestQueue_ReleaseWithoutLease(t *testing.T) {
+	store := NewStore(storagetest.NewFake(), testPolicy)
+	ctx := context.Background()
+	for _, m := range []*pb.CustomerMessage{
+		{Name: "f1", Status: pb.Status_IN_PROCESS},
+		{Name: "f2", Status: pb.Status_IN_PROCESS, Lease: &pb.Lease{ExpireTime: NowMillis() + 60000}},
+		WithLease(&pb.CustomerMessage{Name: "f3"}, "alice", time.Minute),
+	} {
+		m.Category = pb.MessageCategory_FEEDBACK
+		if _, err := store.Create(ctx, m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	q := NewQueue(store, pb.MessageCategory_FEEDBACK, time.Minute)
+	if released := q.ReleaseExpired(ctx); released != 2 {
+		t.Errorf("expected 2 released messages, got %v", released)
+	}
+	for _, name := range []string{"f1", "f2"} {
+		if m, _, _ := store.Get(ctx, name); m.GetStatus() != pb.Status_TO_DO || m.GetLease() != nil {
+			t.Errorf("expected %v back in queue, got %v", name, m)
+		}
+	}
+	if m, _, _ := store.Get(ctx, "f3"); !LeaseHeldBy(m, "alice", NowMillis()) {
+		t.Errorf("expected f3 to stay claimed, got %v", m)
+	}
+}
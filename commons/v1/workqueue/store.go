@@ -0,0 +1,334 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package workqueue provides the category work queues of customer messages:
+// access to the messages in storage and leases that let agents claim them.
+package workqueue
+
+import (
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/retry"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagekey"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	// Message priorities range from 0 to MaxPriority and are indexed in
+	// buckets of PriorityBucketSize.
+	MaxPriority        = 100
+	PriorityBucketSize = 10
+
+	callTimeout = 10 * time.Second
+)
+
+// Creates the key under which a message with the given name is stored
+func MessageKey(name string) *storagepb.Key {
+	return &storagepb.Key{Parts: []*storagepb.Key_Part{{Key: "id", Value: name}}}
+}
+
+// The index keys of the entities found in messages. They are prefixed to keep
+// them apart from the indexes of other objects in the same storage.
+var EntityIndex = map[pb.Entity_Type]string{
+	pb.Entity_PRODUCT:      "entity_product",
+	pb.Entity_ORDER_NUMBER: "entity_order",
+	pb.Entity_EMAIL:        "entity_email",
+}
+
+// Creates a key for the message. Every entity in the message is indexed.
+func CreateKey(m *pb.CustomerMessage) *storagepb.Key {
+	k := &storagepb.Key{
+		Parts: MessageKey(m.GetName()).Parts,
+		IndexedValues: []*storagepb.Key_Part{
+			{Key: "category", Value: IndexedCategory(m).String()},
+			{Key: "status", Value: m.GetStatus().String()},
+			{Key: "sender", Value: storagekey.IndexValue(m.GetSender().GetName())},
+			{Key: "topic", Value: storagekey.IndexValue(m.GetTopic())},
+			{Key: "ingestion", Value: IngestionState(m).String()},
+			{Key: "language", Value: storagekey.IndexValue(m.GetLanguageCode())},
+			{Key: "priority", Value: IndexedPriority(m)},
+			{Key: "needs_categorisation", Value: strconv.FormatBool(m.GetNeedsCategorisation())},
+		},
+	}
+	for _, e := range m.GetEntities() {
+		if idx, ok := EntityIndex[e.GetType()]; ok {
+			k.IndexedValues = append(k.IndexedValues, &storagepb.Key_Part{Key: idx, Value: storagekey.IndexValue(e.GetValue())})
+		}
+	}
+	return k
+}
+
+// Finds the range of priorities a priority is indexed under; the most urgent
+// messages are in bucket MaxPriority / PriorityBucketSize.
+func PriorityBucket(p int32) int32 {
+	if p < 0 {
+		p = 0
+	} else if p > MaxPriority {
+		p = MaxPriority
+	}
+	return p / PriorityBucketSize
+}
+
+// The priority bucket under which the message is stored, zero-padded so that
+// the values sort like the numbers
+func IndexedPriority(m *pb.CustomerMessage) string {
+	return indexedBucket(PriorityBucket(m.GetPriority()))
+}
+
+func indexedBucket(b int32) string {
+	return fmt.Sprintf("%02d", b)
+}
+
+// Finds the status of an ingestion stage
+func FindStage(m *pb.CustomerMessage, name pb.StageStatus_Stage) *pb.StageStatus {
+	for _, st := range m.GetIngestion() {
+		if st.GetStage() == name {
+			return st
+		}
+	}
+	return nil
+}
+
+// Summarises the progress of a message through the ingestion pipeline:
+// FAILED if a stage failed, PENDING if a stage still has to run and DONE
+// otherwise.
+func IngestionState(m *pb.CustomerMessage) pb.StageStatus_State {
+	state := pb.StageStatus_DONE
+	for _, st := range m.GetIngestion() {
+		if st.GetState() == pb.StageStatus_FAILED {
+			return pb.StageStatus_FAILED
+		} else if st.GetState() == pb.StageStatus_PENDING {
+			state = pb.StageStatus_PENDING
+		}
+	}
+	return state
+}
+
+// The category under which the message is stored; messages that have not
+// passed the index stage are kept out of the category queues.
+func IndexedCategory(m *pb.CustomerMessage) pb.MessageCategory {
+	if st := FindStage(m, pb.StageStatus_INDEX); st != nil && st.GetState() != pb.StageStatus_DONE {
+		return pb.MessageCategory_NONE
+	}
+	return m.GetCategory()
+}
+
+// A Store keeps customer messages in the storage service. Etags returned by
+// the store guard against concurrent modification.
+type Store struct {
+	client storagepb.StorageClient
+	retry  retry.Policy
+}
+
+// Creates a store on top of a storage client; failed calls are retried
+// according to the policy.
+func NewStore(client storagepb.StorageClient, policy retry.Policy) *Store {
+	return &Store{client, policy}
+}
+
+// Provides the underlying storage client
+func (s *Store) Client() storagepb.StorageClient {
+	return s.client
+}
+
+// Performs a storage call, retrying it according to the retry policy. Every
+// attempt is limited in time, within the deadline of the context.
+func (s *Store) Call(ctx context.Context, fn func(context.Context) error) error {
+	return s.retry.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+		return fn(ctx)
+	})
+}
+
+// Stores a new message, returning its etag. Fails with AlreadyExists when
+// a message with the same name has been stored before.
+func (s *Store) Create(ctx context.Context, m *pb.CustomerMessage) (string, error) {
+	data, _ := proto.Marshal(m)
+	r := &storagepb.CreateObjectRequest{Key: CreateKey(m), Data: data}
+
+	var resp *storagepb.CreateObjectResponse
+	err := s.Call(ctx, func(ctx context.Context) (err error) {
+		resp, err = s.client.CreateObject(ctx, r)
+		return
+	})
+	if err != nil {
+		log.Printf("WARN: storing message: %v", err)
+		return "", err
+	}
+	log.Printf("DEBUG: stored message %s", m.GetName())
+
+	return resp.GetEtag(), nil
+}
+
+// Retrieves a message and its etag by its name
+func (s *Store) Get(ctx context.Context, name string) (*pb.CustomerMessage, string, error) {
+	r := &storagepb.GetObjectRequest{Keys: []*storagepb.Key{MessageKey(name)}, Limit: 1}
+
+	var objResp *storagepb.GetObjectResponse
+	err := s.Call(ctx, func(ctx context.Context) (err error) {
+		objResp, err = s.client.GetObject(ctx, r)
+		return
+	})
+	if err != nil {
+		log.Printf("WARN: error fetching message '%s'", name)
+		return nil, "", err
+	}
+	if len(objResp.GetEntries()) == 0 {
+		return nil, "", status.Errorf(codes.NotFound, "message '%s' not found", name)
+	}
+
+	e := objResp.GetEntries()[0]
+	m := &pb.CustomerMessage{}
+	err = proto.Unmarshal(e.GetData(), m)
+	if err != nil {
+		log.Printf("WARN: error unmarshalling message '%s'", name)
+		return nil, "", err
+	}
+	m.Name = name
+
+	return m, e.GetEtag(), nil
+}
+
+// Retrieves at most l messages matching any of the storage queries, or all of
+// them when l is 0. The storage service does not honour the limit itself, so
+// it is applied to the results.
+func (s *Store) Query(ctx context.Context, queries []*storagepb.Key, l int32) (msgs []*pb.CustomerMessage, etags []string, err error) {
+	r := &storagepb.GetObjectRequest{Keys: queries, Limit: l}
+
+	var resp *storagepb.GetObjectResponse
+	err = s.Call(ctx, func(ctx context.Context) (err error) {
+		resp, err = s.client.GetObject(ctx, r)
+		return
+	})
+	if err != nil {
+		log.Printf("WARN: error getting stored message: %v", err)
+		return
+	}
+
+	for i := 0; i < len(resp.GetEntries()) && (l <= 0 || i < int(l)); i += 1 {
+		e := resp.GetEntries()[i]
+		m := &pb.CustomerMessage{}
+		_ = proto.Unmarshal(e.GetData(), m)
+		msgs = append(msgs, m)
+		etags = append(etags, e.GetEtag())
+	}
+
+	return
+}
+
+// Creates the queries for messages matching the indexed values, one per
+// language when languages are given.
+func languageQueries(ivs []*storagepb.Key_Part, languages []string) []*storagepb.Key {
+	if len(languages) == 0 {
+		return []*storagepb.Key{{IndexedValues: ivs}}
+	}
+
+	var queries []*storagepb.Key
+	for _, lang := range languages {
+		langIvs := append(append([]*storagepb.Key_Part{}, ivs...), &storagepb.Key_Part{Key: "language", Value: storagekey.IndexValue(lang)})
+		queries = append(queries, &storagepb.Key{IndexedValues: langIvs})
+	}
+	return queries
+}
+
+// Retrieves the messages of a category with the given status. When languages
+// are given, only messages in one of them are retrieved.
+func (s *Store) ByStatus(ctx context.Context, cat pb.MessageCategory, st pb.Status, l int32, languages ...string) ([]*pb.CustomerMessage, []string, error) {
+	ivs := []*storagepb.Key_Part{
+		{Key: "category", Value: cat.String()},
+		{Key: "status", Value: st.String()},
+	}
+	return s.Query(ctx, languageQueries(ivs, languages), l)
+}
+
+// Retrieves the messages of a category in a priority bucket with any of the
+// given statuses. When languages are given, only messages in one of them are
+// retrieved.
+func (s *Store) ByPriority(ctx context.Context, cat pb.MessageCategory, bucket int32, sts []pb.Status, l int32, languages ...string) ([]*pb.CustomerMessage, []string, error) {
+	var queries []*storagepb.Key
+	for _, st := range sts {
+		ivs := []*storagepb.Key_Part{
+			{Key: "category", Value: cat.String()},
+			{Key: "status", Value: st.String()},
+			{Key: "priority", Value: indexedBucket(bucket)},
+		}
+		queries = append(queries, languageQueries(ivs, languages)...)
+	}
+	return s.Query(ctx, queries, l)
+}
+
+// Replaces a message, provided it has not changed since its etag was
+// retrieved. Returns the new etag, or an empty one if the message was
+// modified concurrently.
+func (s *Store) Mutate(ctx context.Context, oldM, newM *pb.CustomerMessage, etag string) (string, error) {
+	newData, _ := proto.Marshal(newM)
+	r := &storagepb.MutateObjectRequest{OldKey: CreateKey(oldM), NewKey: CreateKey(newM), OldEtag: etag, NewData: newData}
+
+	var resp *storagepb.MutateObjectResponse
+	err := s.Call(ctx, func(ctx context.Context) (err error) {
+		resp, err = s.client.MutateObject(ctx, r)
+		return
+	})
+	if err != nil {
+		log.Printf("WARN: could not mutated message \"%s\"", oldM.GetName())
+		return "", err
+	} else if resp.GetNewEtag() == "" {
+		log.Printf("WARN: could not mutated message \"%s\"", oldM.GetName())
+	} else {
+		log.Printf("DEBUG: mutated message \"%s\"", oldM.GetName())
+	}
+
+	return resp.GetNewEtag(), err
+}
+
+// Replaces a message leased by an agent with its update. Fails with
+// InvalidArgument when no agent is given, with FailedPrecondition when the
+// agent holds no lease on it and with Aborted when it was modified
+// concurrently.
+func (s *Store) UpdateLeased(ctx context.Context, name, agent string, update func(*pb.CustomerMessage) (*pb.CustomerMessage, error)) (*pb.CustomerMessage, error) {
+	if err := ValidateAgent(agent); err != nil {
+		return nil, err
+	}
+	m, etag, err := s.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !LeaseHeldBy(m, agent, NowMillis()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "no lease on %s held by '%s'", name, agent)
+	}
+
+	newM, err := update(m)
+	if err != nil {
+		return nil, err
+	}
+	newEtag, err := s.Mutate(ctx, m, newM, etag)
+	if err != nil {
+		return nil, err
+	} else if newEtag == "" {
+		return nil, status.Errorf(codes.Aborted, "concurrent modification of %s", name)
+	}
+
+	return newM, nil
+}
@@ -0,0 +1,38 @@
+package workqueue
+
+import (
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"golang.org/x/net/context"
+	"testing"
+)
+
+func TestStore_QueryLimit(t *testing.T) {
+	store := NewStore(storagetest.NewFake(), testPolicy)
+	ctx := context.Background()
+	for i := 0; i < 5; i += 1 {
+		if _, err := store.Create(ctx, &pb.CustomerMessage{Name: fmt.Sprintf("m%d", i)}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		limit    int32
+		expected int
+	}{
+		{0, 5},
+		{2, 2},
+		{10, 5},
+	}
+	for i, c := range cases {
+		msgs, etags, err := store.Query(ctx, []*storagepb.Key{MessageKey("*")}, c.limit)
+		if err != nil {
+			t.Fatalf("case %v: unexpected error: %v", i, err)
+		}
+		if len(msgs) != c.expected || len(etags) != c.expected {
+			t.Errorf("case %v: expected %v messages, got %v", i, c.expected, len(msgs))
+		}
+	}
+}
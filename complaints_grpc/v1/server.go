@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/complaints/v1"
+	messagingpb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/clients"
+	"github.com/HayoVanLoon/protoworkflow/commons/measure"
+	"github.com/HayoVanLoon/protoworkflow/commons/retry"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const (
+	storageService = "storage-service"
+	defaultPort    = "8080"
+)
+
+// Serves the complaint queue; the claims are handled by the shared work queue.
+type server struct {
+	queue *workqueue.Queue
+}
+
+// Wraps a claimed message as a complaint
+func asComplaint(m *messagingpb.CustomerMessage, err error) (*pb.Complaint, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Complaint{Name: m.GetName(), Message: m}, nil
+}
+
+func (s server) GetComplaint(ctx context.Context, r *pb.GetComplaintRequest) (*pb.Complaint, error) {
+	return asComplaint(s.queue.Claim(ctx, r.GetAgent(), r.GetLanguageCodes()...))
+}
+
+func (s server) RenewComplaint(ctx context.Context, r *pb.RenewComplaintRequest) (*pb.Complaint, error) {
+	return asComplaint(s.queue.Renew(ctx, r.GetName(), r.GetAgent()))
+}
+
+func (s server) ReleaseComplaint(ctx context.Context, r *pb.ReleaseComplaintRequest) (*pb.Complaint, error) {
+	return asComplaint(s.queue.Release(ctx, r.GetName(), r.GetAgent()))
+}
+
+func main() {
+	var port = flag.String("port", defaultPort, "port to listen on")
+	var storageHost = flag.String("storage-host", storageService, "storage service")
+	var storagePort = flag.String("storage-port", defaultPort, "storage service port")
+	var leaseDuration = flag.Duration("lease-duration", workqueue.DefaultLeaseDuration, "time an agent may hold a claimed complaint")
+	var leaseCheckInterval = flag.Duration("lease-check-interval", workqueue.DefaultLeaseCheckInterval, "interval for returning expired claims to the queue")
+	var dialMeasuring = measure.RegisterFlags(defaultPort)
+	flag.Parse()
+
+	lis, err := net.Listen("tcp", ":"+*port)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	recorder, err := dialMeasuring()
+	if err != nil {
+		log.Fatalf("failed to connect to measuring: %v", err)
+	}
+
+	manager := clients.NewManager(map[string]string{
+		storageService: *storageHost + ":" + *storagePort,
+	})
+
+	conn, err := manager.Conn(storageService)
+	if err != nil {
+		log.Fatalf("failed to create server: %v", err)
+	}
+	store := workqueue.NewStore(storagepb.NewStorageClient(conn), retry.DefaultPolicy())
+	q := workqueue.NewQueue(store, messagingpb.MessageCategory_COMPLAINT, *leaseDuration)
+	go workqueue.Reap(*leaseCheckInterval, q)
+
+	s := grpc.NewServer(recorder.ServerOptions()...)
+	pb.RegisterComplaintsServer(s, server{q})
+	healthpb.RegisterHealthServer(s, health.NewServer())
+
+	// Register reflection service on gRPC server.
+	reflection.Register(s)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Print("INFO: shutting down")
+		s.GracefulStop()
+	}()
+
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+	if err := manager.Close(); err != nil {
+		log.Printf("WARN: error closing connections: %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		log.Printf("WARN: error closing measuring: %v", err)
+	}
+}
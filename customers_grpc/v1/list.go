@@ -0,0 +1,138 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagekey"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// Retrieves the customers matching the storage query, oldest first
+func (s server) queryCustomers(ctx context.Context, query *storagepb.Key) ([]*pb.Customer, error) {
+	r := &storagepb.GetObjectRequest{Keys: []*storagepb.Key{query}}
+
+	var resp *storagepb.GetObjectResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		resp, err = s.storage.GetObject(ctx, r)
+		return
+	})
+	if err != nil {
+		log.Printf("WARN: error querying customers: %v", err)
+		return nil, err
+	}
+
+	var cs []*pb.Customer
+	for _, e := range resp.GetEntries() {
+		c := &pb.Customer{}
+		if err := proto.Unmarshal(e.GetData(), c); err != nil {
+			log.Printf("WARN: error unmarshalling customer")
+			continue
+		}
+		cs = append(cs, c)
+	}
+	// names are ULIDs, so they sort by creation time
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].GetName() < cs[j].GetName()
+	})
+	return cs, nil
+}
+
+// Translates a search request into a storage query
+func searchQuery(r *pb.SearchCustomersRequest) *storagepb.Key {
+	q := &storagepb.Key{}
+	if r.GetEmail() != "" {
+		q.IndexedValues = append(q.IndexedValues, &storagepb.Key_Part{Key: "email", Value: storagekey.IndexValue(normaliseEmail(r.GetEmail()))})
+	}
+	if r.GetFullName() != "" {
+		q.IndexedValues = append(q.IndexedValues, &storagepb.Key_Part{Key: "full_name", Value: storagekey.IndexValue(strings.ToLower(r.GetFullName()))})
+	}
+	return q
+}
+
+// Cuts the requested page from customers sorted oldest first. Page tokens
+// hold the name of the last customer on the previous page.
+func paginate(cs []*pb.Customer, size int32, token string) ([]*pb.Customer, string, error) {
+	if token != "" {
+		bs, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return nil, "", status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		last := string(bs)
+		i := sort.Search(len(cs), func(i int) bool {
+			return cs[i].GetName() > last
+		})
+		cs = cs[i:]
+	}
+
+	n := int(size)
+	if n <= 0 {
+		n = defaultPageSize
+	} else if n > maxPageSize {
+		n = maxPageSize
+	}
+	if len(cs) <= n {
+		return cs, "", nil
+	}
+	next := base64.RawURLEncoding.EncodeToString([]byte(cs[n-1].GetName()))
+	return cs[:n], next, nil
+}
+
+func (s server) ListCustomers(ctx context.Context, r *pb.ListCustomersRequest) (*pb.ListCustomersResponse, error) {
+	query := &storagepb.Key{Parts: []*storagepb.Key_Part{{Key: "customer", Value: storagekey.Wildcard}}}
+	cs, err := s.queryCustomers(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page, next, err := paginate(cs, r.GetPageSize(), r.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListCustomersResponse{Customers: page, NextPageToken: next}, nil
+}
+
+func (s server) SearchCustomers(ctx context.Context, r *pb.SearchCustomersRequest) (*pb.SearchCustomersResponse, error) {
+	if r.GetEmail() == "" && r.GetFullName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "no search filters")
+	}
+
+	cs, err := s.queryCustomers(ctx, searchQuery(r))
+	if err != nil {
+		return nil, err
+	}
+
+	page, next, err := paginate(cs, r.GetPageSize(), r.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SearchCustomersResponse{Customers: page, NextPageToken: next}, nil
+}
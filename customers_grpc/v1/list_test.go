@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
+	messagingpb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestServer_ListCustomers(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	var expected []string
+	for i := 0; i < 5; i += 1 {
+		c := &pb.Customer{FullName: fmt.Sprintf("Customer %d", i), Email: fmt.Sprintf("c%d@example.com", i)}
+		created, err := s.CreateCustomer(ctx, &pb.CreateCustomerRequest{Customer: c})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected = append(expected, created.GetName())
+	}
+
+	var actual []string
+	r := &pb.ListCustomersRequest{PageSize: 2}
+	for pages := 0; pages < 5; pages += 1 {
+		resp, err := s.ListCustomers(ctx, r)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, c := range resp.GetCustomers() {
+			actual = append(actual, c.GetName())
+		}
+		if resp.GetNextPageToken() == "" {
+			break
+		}
+		r.PageToken = resp.GetNextPageToken()
+	}
+	if fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	if _, err := s.ListCustomers(ctx, &pb.ListCustomersRequest{PageToken: "!"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestServer_SearchCustomers(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+	for _, c := range []*pb.Customer{
+		{FullName: "Alice Jones", Email: "alice@example.com"},
+		{FullName: "Alice Jones", Email: "alice.jones@example.com"},
+		{FullName: "Bob", Email: "bob@example.com"},
+	} {
+		if _, err := s.CreateCustomer(ctx, &pb.CreateCustomerRequest{Customer: c}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	cases := []struct {
+		r        *pb.SearchCustomersRequest
+		expected int
+	}{
+		{&pb.SearchCustomersRequest{Email: "ALICE@example.com"}, 1},
+		{&pb.SearchCustomersRequest{FullName: "alice jones"}, 2},
+		{&pb.SearchCustomersRequest{FullName: "Alice Jones", Email: "bob@example.com"}, 0},
+		{&pb.SearchCustomersRequest{Email: "carol@example.com"}, 0},
+	}
+	for i, c := range cases {
+		resp, err := s.SearchCustomers(ctx, c.r)
+		if err != nil {
+			t.Errorf("case %v: unexpected error %v", i, err)
+		} else if len(resp.GetCustomers()) != c.expected {
+			t.Errorf("case %v: expected %v customers, got %v", i, c.expected, resp.GetCustomers())
+		}
+	}
+
+	if _, err := s.SearchCustomers(ctx, &pb.SearchCustomersRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestServer_SearchCustomersSharedStorage(t *testing.T) {
+	storage := storagetest.NewFake()
+	s := newServer(storage, testPolicy)
+	ctx := context.Background()
+
+	m := &messagingpb.CustomerMessage{
+		Name:     "m1",
+		Body:     "please mail me at alice@example.com",
+		Entities: []*messagingpb.Entity{{Type: messagingpb.Entity_EMAIL, Value: "alice@example.com"}},
+	}
+	if _, err := workqueue.NewStore(storage, testPolicy).Create(ctx, m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, err := s.CreateCustomer(ctx, &pb.CreateCustomerRequest{Customer: &pb.Customer{FullName: "Alice", Email: "alice@example.com"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := &pb.SearchCustomersRequest{Email: "alice@example.com"}
+	entries, err := storage.GetObject(ctx, &storagepb.GetObjectRequest{Keys: []*storagepb.Key{searchQuery(r)}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries.GetEntries()) != 1 {
+		t.Errorf("expected the message to stay out of the customer index, got %v entries", len(entries.GetEntries()))
+	}
+
+	resp, err := s.SearchCustomers(ctx, r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.GetCustomers()) != 1 || resp.GetCustomers()[0].GetName() != c.GetName() {
+		t.Errorf("expected only %v, got %v", c.GetName(), resp.GetCustomers())
+	}
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/clients"
+	"github.com/HayoVanLoon/protoworkflow/commons/measure"
+	"github.com/HayoVanLoon/protoworkflow/commons/retry"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagekey"
+	"github.com/HayoVanLoon/protoworkflow/commons/ulid"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	storageService = "storage-service"
+	defaultPort    = "8080"
+	callTimeout    = 10 * time.Second
+)
+
+type server struct {
+	storage storagepb.StorageClient
+	retry   retry.Policy
+}
+
+func newServer(storage storagepb.StorageClient, policy retry.Policy) *server {
+	return &server{storage, policy}
+}
+
+// Email addresses are compared case-insensitively
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Creates the key under which a customer is stored
+func customerKey(c *pb.Customer) *storagepb.Key {
+	return &storagepb.Key{
+		Parts: []*storagepb.Key_Part{{Key: "customer", Value: c.GetName()}},
+		IndexedValues: []*storagepb.Key_Part{
+			{Key: "email", Value: storagekey.IndexValue(normaliseEmail(c.GetEmail()))},
+			{Key: "full_name", Value: storagekey.IndexValue(strings.ToLower(c.GetFullName()))},
+		},
+	}
+}
+
+// Creates the key of the record that reserves an email address for one
+// customer
+func emailKey(email string) *storagepb.Key {
+	return &storagepb.Key{Parts: []*storagepb.Key_Part{{Key: "customer_email", Value: storagekey.IndexValue(normaliseEmail(email))}}}
+}
+
+// Checks the parts of a customer that are set by the caller
+func validateCustomer(c *pb.Customer) error {
+	if strings.TrimSpace(c.GetFullName()) == "" {
+		return status.Error(codes.InvalidArgument, "missing full name")
+	} else if !strings.Contains(c.GetEmail(), "@") {
+		return status.Errorf(codes.InvalidArgument, "invalid email '%s'", c.GetEmail())
+	}
+	return nil
+}
+
+// Performs a storage call, retrying it according to the retry policy. Every
+// attempt is limited to callTimeout, within the deadline of the context.
+func (s server) call(ctx context.Context, fn func(context.Context) error) error {
+	return s.retry.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+		return fn(ctx)
+	})
+}
+
+// Reserves an email address for a customer. Fails with AlreadyExists when
+// another customer holds it.
+func (s server) reserveEmail(ctx context.Context, email, name string) error {
+	r := &storagepb.CreateObjectRequest{Key: emailKey(email), Data: []byte(name)}
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		_, err = s.storage.CreateObject(ctx, r)
+		return
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		return status.Errorf(codes.AlreadyExists, "email '%s' is already in use", email)
+	}
+	return err
+}
+
+// Frees an email address for use by other customers
+func (s server) releaseEmail(ctx context.Context, email string) {
+	r := &storagepb.DeleteObjectRequest{Keys: []*storagepb.Key{emailKey(email)}}
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		_, err = s.storage.DeleteObject(ctx, r)
+		return
+	})
+	if err != nil {
+		log.Printf("WARN: could not release email '%s': %v", email, err)
+	}
+}
+
+// Retrieves a customer and its etag by its name
+func (s server) getCustomer(ctx context.Context, name string) (*pb.Customer, string, error) {
+	k := &storagepb.Key{Parts: []*storagepb.Key_Part{{Key: "customer", Value: name}}}
+	r := &storagepb.GetObjectRequest{Keys: []*storagepb.Key{k}, Limit: 1}
+
+	var resp *storagepb.GetObjectResponse
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		resp, err = s.storage.GetObject(ctx, r)
+		return
+	})
+	if err != nil {
+		log.Printf("WARN: error fetching customer '%s': %v", name, err)
+		return nil, "", err
+	}
+	if len(resp.GetEntries()) == 0 {
+		return nil, "", status.Errorf(codes.NotFound, "customer '%s' not found", name)
+	}
+
+	e := resp.GetEntries()[0]
+	c := &pb.Customer{}
+	if err := proto.Unmarshal(e.GetData(), c); err != nil {
+		log.Printf("WARN: error unmarshalling customer '%s'", name)
+		return nil, "", err
+	}
+	return c, e.GetEtag(), nil
+}
+
+func (s server) CreateCustomer(ctx context.Context, r *pb.CreateCustomerRequest) (*pb.Customer, error) {
+	if err := validateCustomer(r.GetCustomer()); err != nil {
+		return nil, err
+	}
+
+	c := proto.Clone(r.GetCustomer()).(*pb.Customer)
+	c.Name = ulid.New()
+	c.Email = strings.TrimSpace(c.GetEmail())
+
+	if err := s.reserveEmail(ctx, c.GetEmail(), c.GetName()); err != nil {
+		return nil, err
+	}
+
+	data, _ := proto.Marshal(c)
+	cr := &storagepb.CreateObjectRequest{Key: customerKey(c), Data: data}
+	err := s.call(ctx, func(ctx context.Context) (err error) {
+		_, err = s.storage.CreateObject(ctx, cr)
+		return
+	})
+	if err != nil {
+		log.Printf("ERROR: error while storing customer: %v", err)
+		s.releaseEmail(ctx, c.GetEmail())
+		return nil, err
+	}
+
+	log.Printf("INFO: created customer %s", c.GetName())
+	return c, nil
+}
+
+func (s server) GetCustomer(ctx context.Context, r *pb.GetCustomerRequest) (*pb.Customer, error) {
+	c, _, err := s.getCustomer(ctx, r.GetName())
+	return c, err
+}
+
+func (s server) UpdateCustomer(ctx context.Context, r *pb.UpdateCustomerRequest) (*pb.Customer, error) {
+	if err := validateCustomer(r.GetCustomer()); err != nil {
+		return nil, err
+	}
+
+	old, etag, err := s.getCustomer(ctx, r.GetCustomer().GetName())
+	if err != nil {
+		return nil, err
+	}
+	c := proto.Clone(r.GetCustomer()).(*pb.Customer)
+	c.Email = strings.TrimSpace(c.GetEmail())
+
+	emailChanged := normaliseEmail(c.GetEmail()) != normaliseEmail(old.GetEmail())
+	if emailChanged {
+		if err := s.reserveEmail(ctx, c.GetEmail(), c.GetName()); err != nil {
+			return nil, err
+		}
+	}
+
+	data, _ := proto.Marshal(c)
+	mr := &storagepb.MutateObjectRequest{OldKey: customerKey(old), NewKey: customerKey(c), OldEtag: etag, NewData: data}
+	var resp *storagepb.MutateObjectResponse
+	err = s.call(ctx, func(ctx context.Context) (err error) {
+		resp, err = s.storage.MutateObject(ctx, mr)
+		return
+	})
+	if err == nil && resp.GetNewEtag() == "" {
+		err = status.Errorf(codes.Aborted, "concurrent modification of %s", c.GetName())
+	}
+	if err != nil {
+		if emailChanged {
+			s.releaseEmail(ctx, c.GetEmail())
+		}
+		return nil, err
+	}
+
+	if emailChanged {
+		s.releaseEmail(ctx, old.GetEmail())
+	}
+	log.Printf("INFO: updated customer %s", c.GetName())
+	return c, nil
+}
+
+func main() {
+	var port = flag.String("port", defaultPort, "port to listen on")
+	var storageHost = flag.String("storage-host", storageService, "storage service")
+	var storagePort = flag.String("storage-port", defaultPort, "storage service port")
+	var dialMeasuring = measure.RegisterFlags(defaultPort)
+	flag.Parse()
+
+	lis, err := net.Listen("tcp", ":"+*port)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	recorder, err := dialMeasuring()
+	if err != nil {
+		log.Fatalf("failed to connect to measuring: %v", err)
+	}
+
+	manager := clients.NewManager(map[string]string{
+		storageService: *storageHost + ":" + *storagePort,
+	})
+
+	conn, err := manager.Conn(storageService)
+	if err != nil {
+		log.Fatalf("failed to create server: %v", err)
+	}
+
+	s := grpc.NewServer(recorder.ServerOptions()...)
+	pb.RegisterCustomersServer(s, newServer(storagepb.NewStorageClient(conn), retry.DefaultPolicy()))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+
+	// Register reflection service on gRPC server.
+	reflection.Register(s)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Print("INFO: shutting down")
+		s.GracefulStop()
+	}()
+
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+	if err := manager.Close(); err != nil {
+		log.Printf("WARN: error closing connections: %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		log.Printf("WARN: error closing measuring: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/retry"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+var testPolicy = retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 2}
+
+func newTestServer() *server {
+	return newServer(storagetest.NewFake(), testPolicy)
+}
+
+func TestServer_CreateCustomer(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+
+	cases := []struct {
+		customer *pb.Customer
+		expected codes.Code
+	}{
+		{&pb.Customer{FullName: "Alice Jones", Email: "alice@example.com"}, codes.OK},
+		{&pb.Customer{FullName: "Alice Smith", Email: " Alice@Example.com"}, codes.AlreadyExists},
+		{&pb.Customer{FullName: "Bob", Email: "bob@example.com"}, codes.OK},
+		{&pb.Customer{FullName: "", Email: "carol@example.com"}, codes.InvalidArgument},
+		{&pb.Customer{FullName: "Carol", Email: "carol"}, codes.InvalidArgument},
+	}
+	for i, c := range cases {
+		actual, err := s.CreateCustomer(ctx, &pb.CreateCustomerRequest{Customer: c.customer})
+		if status.Code(err) != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if actual.GetName() == "" {
+			t.Errorf("case %v: expected name to be set", i)
+		}
+		stored, err := s.GetCustomer(ctx, &pb.GetCustomerRequest{Name: actual.GetName()})
+		if err != nil {
+			t.Errorf("case %v: unexpected error %v", i, err)
+		} else if stored.GetEmail() != c.customer.GetEmail() {
+			t.Errorf("case %v: expected %v, got %v", i, c.customer, stored)
+		}
+	}
+
+	if _, err := s.GetCustomer(ctx, &pb.GetCustomerRequest{Name: "nope"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v, got %v", codes.NotFound, err)
+	}
+}
+
+func TestServer_UpdateCustomer(t *testing.T) {
+	s := newTestServer()
+	ctx := context.Background()
+
+	alice, _ := s.CreateCustomer(ctx, &pb.CreateCustomerRequest{Customer: &pb.Customer{FullName: "Alice", Email: "alice@example.com"}})
+	bob, _ := s.CreateCustomer(ctx, &pb.CreateCustomerRequest{Customer: &pb.Customer{FullName: "Bob", Email: "bob@example.com"}})
+
+	taken := &pb.Customer{Name: alice.GetName(), FullName: "Alice", Email: "BOB@example.com"}
+	if _, err := s.UpdateCustomer(ctx, &pb.UpdateCustomerRequest{Customer: taken}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected %v, got %v", codes.AlreadyExists, err)
+	}
+
+	moved := &pb.Customer{Name: alice.GetName(), FullName: "Alice Jones", Title: "Dr.", Email: "alice@example.org"}
+	if _, err := s.UpdateCustomer(ctx, &pb.UpdateCustomerRequest{Customer: moved}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual, _ := s.GetCustomer(ctx, &pb.GetCustomerRequest{Name: alice.GetName()})
+	if actual.GetEmail() != "alice@example.org" || actual.GetTitle() != "Dr." {
+		t.Errorf("expected %v, got %v", moved, actual)
+	}
+
+	// the old address is free again
+	bob.Email = "alice@example.com"
+	if _, err := s.UpdateCustomer(ctx, &pb.UpdateCustomerRequest{Customer: bob}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	unknown := &pb.Customer{Name: "nope", FullName: "Nobody", Email: "nobody@example.com"}
+	if _, err := s.UpdateCustomer(ctx, &pb.UpdateCustomerRequest{Customer: unknown}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v, got %v", codes.NotFound, err)
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/HayoVanLoon/protoworkflow/feedbacks_grpc/v1
+
+go 1.12
+
+require (
+	github.com/HayoVanLoon/protoworkflow-genproto v0.0.0-20190625192144-df35325a481d
+	github.com/HayoVanLoon/protoworkflow/commons v0.0.0
+	google.golang.org/grpc v1.21.1
+)
+
+replace github.com/HayoVanLoon/protoworkflow/commons => ../../commons/v1
@@ -21,27 +21,37 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/complaints/v1"
 	"github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/contact/v1"
+	"github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/feedbacks/v1"
 	"github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/questions/v1"
 	"github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/clients"
 	"github.com/golang/protobuf/jsonpb"
-	"google.golang.org/grpc"
 	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 const (
-	contactService   = "contact-service"
-	messagingService = "messaging-service"
-	storageService   = "storage-service"
-	defaultPort      = 8080
+	complaintsService = "complaints-service"
+	contactService    = "contact-service"
+	feedbacksService  = "feedbacks-service"
+	messagingService  = "messaging-service"
+	questionsService  = "questions-service"
+	storageService    = "storage-service"
+	defaultPort       = 8080
+
+	shutdownTimeout = 10 * time.Second
 )
 
 type static struct {
@@ -114,55 +124,7 @@ func staticHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Establishes a connection to a service
-func getConn(host, port string) (*grpc.ClientConn, error) {
-	conn, err := grpc.Dial(host+":"+port, grpc.WithInsecure())
-	if err != nil {
-		return nil, fmt.Errorf("did not connect: %v", err)
-	}
-	return conn, nil
-}
-
-// Provides a connection-closing function
-func closeConnFn(conn *grpc.ClientConn) func() {
-	return func() {
-		if err := conn.Close(); err != nil {
-			log.Printf("WARN: error closing connection: %v", err)
-		}
-	}
-}
-
-// Provides a contact client
-func getContactClient(host, port string) (contact.ContactClient, func(), error) {
-	conn, err := getConn(host, port)
-	if err != nil {
-		log.Printf("ERROR: could not open connection to %s:%s", host, port)
-		return nil, nil, err
-	}
-	return contact.NewContactClient(conn), closeConnFn(conn), err
-}
-
-// Provides a messaging client
-func getMessagingClient(host, port string) (messaging.MessagingClient, func(), error) {
-	conn, err := getConn(host, port)
-	if err != nil {
-		log.Printf("ERROR: could not open connection to %s:%s", host, port)
-		return nil, nil, err
-	}
-	return messaging.NewMessagingClient(conn), closeConnFn(conn), err
-}
-
-// Provides a storage client
-func getStorageClient(host, port string) (storage.StorageClient, func(), error) {
-	conn, err := getConn(host, port)
-	if err != nil {
-		log.Printf("ERROR: could not open connection to %s:%s", host, port)
-		return nil, nil, err
-	}
-	return storage.NewStorageClient(conn), closeConnFn(conn), err
-}
-
-func contactHandlerFn(host, port string) func(http.ResponseWriter, *http.Request) {
+func contactHandlerFn(c contact.ContactClient) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" {
 			_ = r.ParseForm()
@@ -185,17 +147,10 @@ func contactHandlerFn(host, port string) func(http.ResponseWriter, *http.Request
 				m.ProductId = pi[0]
 			}
 
-			c, closeConn, err := getContactClient(host, port)
-			if err != nil {
-				log.Printf("%v", err)
-				return
-			}
-			defer closeConn()
-
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
-			_, err = c.CreateMessage(ctx, m)
+			_, err := c.CreateMessage(ctx, m)
 			if err != nil {
 				log.Printf("%v", err)
 				w.WriteHeader(500)
@@ -209,21 +164,15 @@ func contactHandlerFn(host, port string) func(http.ResponseWriter, *http.Request
 	}
 }
 
-func messagesHandlerFn(host, port string) func(http.ResponseWriter, *http.Request) {
+func messagesHandlerFn(c messaging.MessagingClient) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c, closeConn, err := getMessagingClient(host, port)
-		if err != nil {
-			log.Printf("error creating messaging client %v", err)
-			w.WriteHeader(500)
-			return
-		}
-		defer closeConn()
-
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
 		m := &messaging.SearchMessagesRequest{
-			Status: []messaging.Status{messaging.Status_TO_DO},
+			Status:    []messaging.Status{messaging.Status_TO_DO},
+			Order:     messaging.SearchMessagesRequest_NEWEST_FIRST,
+			PageToken: r.FormValue("page_token"),
 		}
 
 		resp, err := c.SearchMessages(ctx, m)
@@ -239,20 +188,12 @@ func messagesHandlerFn(host, port string) func(http.ResponseWriter, *http.Reques
 	}
 }
 
-func getQuestionHandlerFn(host, port string) func(http.ResponseWriter, *http.Request) {
+func getQuestionHandlerFn(c questions.QuestionsClient) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c, closeConn, err := getMessagingClient(host, port)
-		if err != nil {
-			log.Printf("error creating messaging client %v", err)
-			w.WriteHeader(500)
-			return
-		}
-		defer closeConn()
-
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		m := &messaging.GetQuestionRequest{}
+		m := &questions.GetQuestionRequest{Agent: r.FormValue("agent")}
 
 		resp, err := c.GetQuestion(ctx, m)
 		if err != nil {
@@ -267,20 +208,12 @@ func getQuestionHandlerFn(host, port string) func(http.ResponseWriter, *http.Req
 	}
 }
 
-func getComplaintHandlerFn(host, port string) func(http.ResponseWriter, *http.Request) {
+func getComplaintHandlerFn(c complaints.ComplaintsClient) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c, closeConn, err := getMessagingClient(host, port)
-		if err != nil {
-			log.Printf("error creating messaging client %v", err)
-			w.WriteHeader(500)
-			return
-		}
-		defer closeConn()
-
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		m := &messaging.GetComplaintRequest{}
+		m := &complaints.GetComplaintRequest{Agent: r.FormValue("agent")}
 
 		resp, err := c.GetComplaint(ctx, m)
 		if err != nil {
@@ -295,20 +228,12 @@ func getComplaintHandlerFn(host, port string) func(http.ResponseWriter, *http.Re
 	}
 }
 
-func getFeedbackHandlerFn(host, port string) func(http.ResponseWriter, *http.Request) {
+func getFeedbackHandlerFn(c feedbacks.FeedbacksClient) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c, closeConn, err := getMessagingClient(host, port)
-		if err != nil {
-			log.Printf("error creating messaging client %v", err)
-			w.WriteHeader(500)
-			return
-		}
-		defer closeConn()
-
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		m := &messaging.GetFeedbackRequest{}
+		m := &feedbacks.GetFeedbackRequest{Agent: r.FormValue("agent")}
 
 		resp, err := c.GetFeedback(ctx, m)
 		if err != nil {
@@ -323,16 +248,8 @@ func getFeedbackHandlerFn(host, port string) func(http.ResponseWriter, *http.Req
 	}
 }
 
-func getStorageStatsHandlerFn(host, port string) func(http.ResponseWriter, *http.Request) {
+func getStorageStatsHandlerFn(c storage.StorageClient) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c, closeConn, err := getStorageClient(host, port)
-		if err != nil {
-			log.Printf("error creating messaging client %v", err)
-			w.WriteHeader(500)
-			return
-		}
-		defer closeConn()
-
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
@@ -357,18 +274,87 @@ func main() {
 	var contactPort = flag.Int("contact-port", defaultPort, "contact service port")
 	var messagingHost = flag.String("messaging-host", messagingService, "messaging service")
 	var messagingPort = flag.Int("messaging-port", defaultPort, "messaging service port")
+	var questionsHost = flag.String("questions-host", questionsService, "questions service")
+	var questionsPort = flag.Int("questions-port", defaultPort, "questions service port")
+	var complaintsHost = flag.String("complaints-host", complaintsService, "complaints service")
+	var complaintsPort = flag.Int("complaints-port", defaultPort, "complaints service port")
+	var feedbacksHost = flag.String("feedbacks-host", feedbacksService, "feedbacks service")
+	var feedbacksPort = flag.Int("feedbacks-port", defaultPort, "feedbacks service port")
 	var storageHost = flag.String("storage-host", storageService, "storage service")
 	var storagePort = flag.Int("storage-port", defaultPort, "storage service port")
 	flag.Parse()
 
+	manager := clients.NewManager(map[string]string{
+		contactService:    *contactHost + ":" + strconv.Itoa(*contactPort),
+		messagingService:  *messagingHost + ":" + strconv.Itoa(*messagingPort),
+		questionsService:  *questionsHost + ":" + strconv.Itoa(*questionsPort),
+		complaintsService: *complaintsHost + ":" + strconv.Itoa(*complaintsPort),
+		feedbacksService:  *feedbacksHost + ":" + strconv.Itoa(*feedbacksPort),
+		storageService:    *storageHost + ":" + strconv.Itoa(*storagePort),
+	})
+
+	contactConn, err := manager.Conn(contactService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	messagingConn, err := manager.Conn(messagingService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	questionsConn, err := manager.Conn(questionsService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	complaintsConn, err := manager.Conn(complaintsService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	feedbacksConn, err := manager.Conn(feedbacksService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	storageConn, err := manager.Conn(storageService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	contactClient := contact.NewContactClient(contactConn)
+	messagingClient := messaging.NewMessagingClient(messagingConn)
+	questionsClient := questions.NewQuestionsClient(questionsConn)
+	complaintsClient := complaints.NewComplaintsClient(complaintsConn)
+	feedbacksClient := feedbacks.NewFeedbacksClient(feedbacksConn)
+	storageClient := storage.NewStorageClient(storageConn)
+
 	http.HandleFunc("/", handler)
-	http.HandleFunc("/contact", contactHandlerFn(*contactHost, strconv.Itoa(*contactPort)))
-	// http.HandleFunc("/messages", messagesHandlerFn(*messagingHost, *messagingPort))
-	http.HandleFunc("/question", getQuestionHandlerFn(*messagingHost, strconv.Itoa(*messagingPort)))
-	http.HandleFunc("/complaint", getComplaintHandlerFn(*messagingHost, strconv.Itoa(*messagingPort)))
-	http.HandleFunc("/feedback", getFeedbackHandlerFn(*messagingHost, strconv.Itoa(*messagingPort)))
-	http.HandleFunc("/storage-stats", getStorageStatsHandlerFn(*storageHost, strconv.Itoa(*storagePort)))
+	http.HandleFunc("/contact", contactHandlerFn(contactClient))
+	http.HandleFunc("/messages", messagesHandlerFn(messagingClient))
+	http.HandleFunc("/question", getQuestionHandlerFn(questionsClient))
+	http.HandleFunc("/complaint", getComplaintHandlerFn(complaintsClient))
+	http.HandleFunc("/feedback", getFeedbackHandlerFn(feedbacksClient))
+	http.HandleFunc("/storage-stats", getStorageStatsHandlerFn(storageClient))
 	http.HandleFunc("/static/", staticHandler)
 
-	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(*port), nil))
+	srv := &http.Server{Addr: ":" + strconv.Itoa(*port)}
+
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Print("INFO: shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("WARN: error during shutdown: %v", err)
+		}
+		close(done)
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+	if err := manager.Close(); err != nil {
+		log.Printf("WARN: error closing connections: %v", err)
+	}
 }
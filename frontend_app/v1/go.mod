@@ -4,5 +4,8 @@ go 1.12
 
 require (
 	github.com/HayoVanLoon/protoworkflow-genproto v0.0.0-20190625192144-df35325a481d // indirect
+	github.com/HayoVanLoon/protoworkflow/commons v0.0.0
 	google.golang.org/grpc v1.21.1 // indirect
 )
+
+replace github.com/HayoVanLoon/protoworkflow/commons => ../../commons/v1
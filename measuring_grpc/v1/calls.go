@@ -0,0 +1,125 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	commonpb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// A call log and the order in which it was recorded
+type entry struct {
+	seq  uint64
+	call *commonpb.ServiceCallLog
+}
+
+// Keeps the most recent call logs; older logs are overwritten once the
+// capacity is reached.
+type callLog struct {
+	mu      sync.RWMutex
+	entries []entry
+	next    int
+	full    bool
+	seq     uint64
+}
+
+func newCallLog(capacity int) *callLog {
+	return &callLog{entries: make([]entry, capacity)}
+}
+
+func (l *callLog) add(c *commonpb.ServiceCallLog) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) == 0 {
+		return
+	}
+	l.seq += 1
+	l.entries[l.next] = entry{l.seq, c}
+	l.next += 1
+	if l.next == len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Provides the call logs matching the filters, most recently recorded first
+func (l *callLog) recent(service, rpc string) []entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	var result []entry
+	for i := 1; i <= n; i += 1 {
+		e := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if (service == "" || e.call.GetService() == service) && (rpc == "" || e.call.GetRpc() == rpc) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Cuts the requested page from call logs sorted most recently recorded first.
+// Page tokens hold the sequence number of the last call on the previous page;
+// the page continues with the first call recorded before it.
+func paginate(entries []entry, size int32, token string) ([]*commonpb.ServiceCallLog, string, error) {
+	if token != "" {
+		bs, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return nil, "", status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		last, err := strconv.ParseUint(string(bs), 10, 64)
+		if err != nil {
+			return nil, "", status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		i := sort.Search(len(entries), func(i int) bool {
+			return entries[i].seq < last
+		})
+		entries = entries[i:]
+	}
+
+	n := int(size)
+	if n <= 0 {
+		n = defaultPageSize
+	} else if n > maxPageSize {
+		n = maxPageSize
+	}
+	next := ""
+	if len(entries) > n {
+		next = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(entries[n-1].seq, 10)))
+		entries = entries[:n]
+	}
+
+	var calls []*commonpb.ServiceCallLog
+	for _, e := range entries {
+		calls = append(calls, e.call)
+	}
+	return calls, next, nil
+}
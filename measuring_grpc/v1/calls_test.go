@@ -0,0 +1,42 @@
+package main
+
+import (
+	commonpb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	// names need not follow the order in which calls were recorded
+	var entries []entry
+	for i, name := range []string{"c", "y", "b", "z", "a"} {
+		entries = append(entries, entry{uint64(5 - i), &commonpb.ServiceCallLog{Name: name}})
+	}
+
+	expected := []string{"cy", "bz", "a"}
+	token := ""
+	for i, exp := range expected {
+		page, next, err := paginate(entries, 2, token)
+		if err != nil {
+			t.Fatalf("page %v: unexpected error %v", i, err)
+		}
+		actual := ""
+		for _, c := range page {
+			actual += c.GetName()
+		}
+		if actual != exp {
+			t.Errorf("page %v: expected %v, got %v", i, exp, actual)
+		}
+		if (next == "") != (i == len(expected)-1) {
+			t.Errorf("page %v: unexpected next page token '%s'", i, next)
+		}
+		token = next
+	}
+
+	for i, token := range []string{"!", "Yw"} {
+		if _, _, err := paginate(entries, 2, token); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("case %v: expected %v, got %v", i, codes.InvalidArgument, err)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+module github.com/HayoVanLoon/protoworkflow/measuring_grpc/v1
+
+go 1.12
+
+require (
+	github.com/HayoVanLoon/protoworkflow-genproto v0.0.0-20190625192144-df35325a481d
+	github.com/HayoVanLoon/protoworkflow/commons v0.0.0
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
+	google.golang.org/grpc v1.21.1
+)
+
+replace github.com/HayoVanLoon/protoworkflow/commons => ../../commons/v1
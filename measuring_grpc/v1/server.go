@@ -0,0 +1,145 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	commonpb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/common"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/measuring/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/ulid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const (
+	defaultPort     = "8080"
+	defaultMaxCalls = 10000
+)
+
+// Call logs and aggregates are kept in memory. Storing them through the
+// storage service would have that service report on its own traffic.
+type server struct {
+	calls *callLog
+	stats *aggregator
+}
+
+func newServer(maxCalls int) *server {
+	return &server{newCallLog(maxCalls), newAggregator()}
+}
+
+// Checks a reported call
+func validateCall(r *pb.CreateServiceCallRequest) error {
+	if r.GetServiceName() == "" {
+		return status.Error(codes.InvalidArgument, "missing service name")
+	} else if r.GetLatencyMicros() < 0 {
+		return status.Errorf(codes.InvalidArgument, "negative latency %d", r.GetLatencyMicros())
+	}
+	return nil
+}
+
+func (s server) record(r *pb.CreateServiceCallRequest) {
+	s.calls.add(&commonpb.ServiceCallLog{
+		Name:          ulid.New(),
+		Service:       r.GetServiceName(),
+		Rpc:           r.GetRpcName(),
+		Request:       r.GetRequest(),
+		Response:      r.GetResponse(),
+		StartTime:     r.GetStartTime(),
+		LatencyMicros: r.GetLatencyMicros(),
+		Code:          r.GetCode(),
+	})
+	s.stats.add(r.GetServiceName(), r.GetRpcName(), r.GetLatencyMicros(), codes.Code(r.GetCode()))
+}
+
+func (s server) CreateServiceCall(ctx context.Context, r *pb.CreateServiceCallRequest) (*pb.CreateServiceCallResponse, error) {
+	if err := validateCall(r); err != nil {
+		return nil, err
+	}
+	s.record(r)
+	return &pb.CreateServiceCallResponse{}, nil
+}
+
+func (s server) CreateServiceCalls(ctx context.Context, r *pb.CreateServiceCallsRequest) (*pb.CreateServiceCallsResponse, error) {
+	var recorded int32
+	for _, c := range r.GetServiceCalls() {
+		if err := validateCall(c); err != nil {
+			log.Printf("WARN: skipping call: %v", err)
+			continue
+		}
+		s.record(c)
+		recorded += 1
+	}
+	return &pb.CreateServiceCallsResponse{Recorded: recorded}, nil
+}
+
+func (s server) ListServiceCalls(ctx context.Context, r *pb.ListServiceCallsRequest) (*pb.ListServiceCallsResponse, error) {
+	calls := s.calls.recent(r.GetServiceName(), r.GetRpcName())
+	page, next, err := paginate(calls, r.GetPageSize(), r.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListServiceCallsResponse{ServiceCalls: page, NextPageToken: next}, nil
+}
+
+func (s server) GetCallSummary(ctx context.Context, r *pb.GetCallSummaryRequest) (*pb.GetCallSummaryResponse, error) {
+	return &pb.GetCallSummaryResponse{Summaries: s.stats.summaries(r.GetServiceName(), r.GetRpcName())}, nil
+}
+
+func main() {
+	var port = flag.String("port", defaultPort, "port to listen on")
+	var maxCalls = flag.Int("max-calls", defaultMaxCalls, "number of recent calls to keep in memory; calls and summaries are lost on restart")
+	flag.Parse()
+
+	if *maxCalls < 0 {
+		log.Fatalf("invalid -max-calls: %v", *maxCalls)
+	}
+
+	lis, err := net.Listen("tcp", ":"+*port)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+
+	s := grpc.NewServer()
+	pb.RegisterMeasuringServer(s, newServer(*maxCalls))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+
+	// Register reflection service on gRPC server.
+	reflection.Register(s)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Print("INFO: shutting down")
+		s.GracefulStop()
+	}()
+
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/measuring/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestServer_CreateServiceCall(t *testing.T) {
+	cases := []struct {
+		r        *pb.CreateServiceCallRequest
+		expected codes.Code
+	}{
+		{&pb.CreateServiceCallRequest{ServiceName: "storage", RpcName: "GetObject", LatencyMicros: 10}, codes.OK},
+		{&pb.CreateServiceCallRequest{ServiceName: "storage", LatencyMicros: 10}, codes.OK},
+		{&pb.CreateServiceCallRequest{RpcName: "GetObject"}, codes.InvalidArgument},
+		{&pb.CreateServiceCallRequest{ServiceName: "storage", LatencyMicros: -1}, codes.InvalidArgument},
+	}
+	for i, c := range cases {
+		s := newServer(10)
+		_, err := s.CreateServiceCall(context.Background(), c.r)
+		if status.Code(err) != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, err)
+		}
+	}
+}
+
+func TestServer_CreateServiceCalls(t *testing.T) {
+	s := newServer(10)
+	r := &pb.CreateServiceCallsRequest{ServiceCalls: []*pb.CreateServiceCallRequest{
+		{ServiceName: "storage", RpcName: "GetObject"},
+		{RpcName: "GetObject"},
+		{ServiceName: "storage", RpcName: "CreateObject"},
+	}}
+	resp, err := s.CreateServiceCalls(context.Background(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.GetRecorded() != 2 {
+		t.Errorf("expected 2 calls recorded, got %v", resp.GetRecorded())
+	}
+	if calls := s.calls.recent("", ""); len(calls) != 2 {
+		t.Errorf("expected 2 calls logged, got %v", calls)
+	}
+}
+
+func TestServer_ListServiceCalls(t *testing.T) {
+	s := newServer(4)
+	ctx := context.Background()
+	for _, rpc := range []string{"a", "b", "a", "b", "a", "b"} {
+		if _, err := s.CreateServiceCall(ctx, &pb.CreateServiceCallRequest{ServiceName: "storage", RpcName: rpc}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// only the last four calls are kept
+	cases := []struct {
+		rpc      string
+		size     int32
+		expected []int
+	}{
+		{"", 0, []int{4}},
+		{"", 3, []int{3, 1}},
+		{"a", 1, []int{1, 1}},
+		{"c", 0, []int{0}},
+	}
+	for i, c := range cases {
+		r := &pb.ListServiceCallsRequest{ServiceName: "storage", RpcName: c.rpc, PageSize: c.size}
+		var last string
+		for j, exp := range c.expected {
+			resp, err := s.ListServiceCalls(ctx, r)
+			if err != nil {
+				t.Fatalf("case %v: unexpected error %v", i, err)
+			}
+			if len(resp.GetServiceCalls()) != exp {
+				t.Errorf("case %v, page %v: expected %v calls, got %v", i, j, exp, len(resp.GetServiceCalls()))
+			}
+			for _, call := range resp.GetServiceCalls() {
+				if last != "" && call.GetName() >= last {
+					t.Errorf("case %v, page %v: expected newest first, got %s after %s", i, j, call.GetName(), last)
+				}
+				last = call.GetName()
+			}
+			if (resp.GetNextPageToken() == "") != (j == len(c.expected)-1) {
+				t.Errorf("case %v, page %v: unexpected next page token '%s'", i, j, resp.GetNextPageToken())
+			}
+			r.PageToken = resp.GetNextPageToken()
+		}
+	}
+}
+
+func TestServer_GetCallSummary(t *testing.T) {
+	s := newServer(10)
+	ctx := context.Background()
+	for _, r := range []*pb.CreateServiceCallRequest{
+		{ServiceName: "storage", RpcName: "GetObject", LatencyMicros: 100},
+		{ServiceName: "storage", RpcName: "GetObject", LatencyMicros: 300, Code: int32(codes.NotFound)},
+		{ServiceName: "storage", RpcName: "CreateObject", LatencyMicros: 50},
+		{ServiceName: "messaging", RpcName: "CreateMessage", LatencyMicros: 1000},
+	} {
+		if _, err := s.CreateServiceCall(ctx, r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	resp, _ := s.GetCallSummary(ctx, &pb.GetCallSummaryRequest{})
+	var names []string
+	for _, sum := range resp.GetSummaries() {
+		names = append(names, sum.GetServiceName()+"."+sum.GetRpcName())
+	}
+	if len(names) != 3 || names[0] != "messaging.CreateMessage" || names[1] != "storage.CreateObject" || names[2] != "storage.GetObject" {
+		t.Errorf("unexpected summaries %v", names)
+	}
+
+	resp, _ = s.GetCallSummary(ctx, &pb.GetCallSummaryRequest{ServiceName: "storage", RpcName: "GetObject"})
+	if len(resp.GetSummaries()) != 1 {
+		t.Fatalf("expected 1 summary, got %v", resp.GetSummaries())
+	}
+	sum := resp.GetSummaries()[0]
+	if sum.GetCount() != 2 || sum.GetErrorCount() != 1 || sum.GetMeanLatencyMicros() != 200 || sum.GetMaxLatencyMicros() != 300 {
+		t.Errorf("unexpected summary %v", sum)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/measuring/v1"
+	"google.golang.org/grpc/codes"
+	"sort"
+	"sync"
+)
+
+const (
+	// Upper bound of the first latency bucket; every next bucket doubles it
+	firstBucketMicros = 100
+	latencyBuckets    = 24
+)
+
+// Provides the upper bound of a latency bucket
+func bucketBound(i int) int64 {
+	return firstBucketMicros << uint(i)
+}
+
+// Finds the bucket a latency falls into; the last bucket is unbounded.
+func bucketOf(latency int64) int {
+	for i := 0; i < latencyBuckets-1; i += 1 {
+		if latency <= bucketBound(i) {
+			return i
+		}
+	}
+	return latencyBuckets - 1
+}
+
+// Counts and latencies of the calls to one rpc
+type stats struct {
+	count   int64
+	errors  int64
+	total   int64
+	max     int64
+	buckets [latencyBuckets]int64
+}
+
+func (st *stats) add(latency int64, code codes.Code) {
+	st.count += 1
+	if code != codes.OK {
+		st.errors += 1
+	}
+	st.total += latency
+	if latency > st.max {
+		st.max = latency
+	}
+	st.buckets[bucketOf(latency)] += 1
+}
+
+// Estimates a latency percentile as the upper bound of the bucket it falls
+// into, capped at the maximum latency seen.
+func (st *stats) percentile(p float64) int64 {
+	if st.count == 0 {
+		return 0
+	}
+	rank := int64(p*float64(st.count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range st.buckets {
+		seen += n
+		if seen >= rank {
+			if b := bucketBound(i); i < latencyBuckets-1 && b < st.max {
+				return b
+			}
+			return st.max
+		}
+	}
+	return st.max
+}
+
+func (st *stats) summary(service, rpc string) *pb.CallSummary {
+	s := &pb.CallSummary{
+		ServiceName:      service,
+		RpcName:          rpc,
+		Count:            st.count,
+		ErrorCount:       st.errors,
+		P50LatencyMicros: st.percentile(.5),
+		P95LatencyMicros: st.percentile(.95),
+		P99LatencyMicros: st.percentile(.99),
+		MaxLatencyMicros: st.max,
+	}
+	if st.count > 0 {
+		s.MeanLatencyMicros = st.total / st.count
+	}
+	return s
+}
+
+type rpcKey struct {
+	service, rpc string
+}
+
+// Aggregates calls per service and rpc
+type aggregator struct {
+	mu    sync.Mutex
+	stats map[rpcKey]*stats
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{stats: make(map[rpcKey]*stats)}
+}
+
+func (a *aggregator) add(service, rpc string, latency int64, code codes.Code) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	k := rpcKey{service, rpc}
+	st, ok := a.stats[k]
+	if !ok {
+		st = &stats{}
+		a.stats[k] = st
+	}
+	st.add(latency, code)
+}
+
+// Summarises the rpcs matching the filters, ordered by service and rpc
+func (a *aggregator) summaries(service, rpc string) []*pb.CallSummary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var result []*pb.CallSummary
+	for k, st := range a.stats {
+		if (service == "" || k.service == service) && (rpc == "" || k.rpc == rpc) {
+			result = append(result, st.summary(k.service, k.rpc))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].GetServiceName() != result[j].GetServiceName() {
+			return result[i].GetServiceName() < result[j].GetServiceName()
+		}
+		return result[i].GetRpcName() < result[j].GetRpcName()
+	})
+	return result
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"google.golang.org/grpc/codes"
+	"testing"
+)
+
+func TestStats_Percentile(t *testing.T) {
+	st := &stats{}
+	for i := int64(1); i <= 100; i += 1 {
+		st.add(i*10, codes.OK)
+	}
+	cases := []struct {
+		p        float64
+		expected int64
+	}{
+		{.01, 100},
+		{.5, 800},
+		{.95, 1000},
+		{.99, 1000},
+		{1, 1000},
+	}
+	for i, c := range cases {
+		if actual := st.percentile(c.p); actual != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+
+	if actual := (&stats{}).percentile(.5); actual != 0 {
+		t.Errorf("expected 0 without calls, got %v", actual)
+	}
+}
@@ -21,8 +21,8 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	contactpb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/contact/v1"
 	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	questionspb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/questions/v1"
 	"google.golang.org/grpc"
 	"log"
 	"time"
@@ -42,7 +42,7 @@ func getConn(host, port string) (*grpc.ClientConn, error) {
 }
 
 func createMessage(host, port string, m *pb.CustomerMessage) error {
-	r := &pb.CreateMessageRequest{Message: &pb.CreateMessageRequest_CustomerMessage{m}}
+	r := &pb.CreateMessageRequest{Message: &pb.CreateMessageRequest_CustomerMessage{CustomerMessage: m}}
 
 	conn, err := getConn(host, port)
 	defer func() {
@@ -67,7 +67,7 @@ func createMessage(host, port string, m *pb.CustomerMessage) error {
 }
 
 func getQuestion(host, port string) error {
-	r := &pb.GetQuestionRequest{}
+	r := &questionspb.GetQuestionRequest{Agent: "test-client"}
 
 	conn, err := getConn(host, port)
 	defer func() {
@@ -76,7 +76,7 @@ func getQuestion(host, port string) error {
 		}
 	}()
 
-	c := pb.NewMessagingClient(conn)
+	c := questionspb.NewQuestionsClient(conn)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -91,26 +91,29 @@ func getQuestion(host, port string) error {
 	return err
 }
 
-// Makes several calls to a messaging server.
+// Makes several calls to a messaging server and claims a question from the
+// questions server.
 //
 // Meant for debugging purposes.
 func main() {
 	var host = flag.String("host", defaultHost, "messaging service host")
 	var port = flag.String("port", defaultPort, "messaging service port")
+	var questionsHost = flag.String("questions-host", defaultHost, "questions service host")
+	var questionsPort = flag.String("questions-port", defaultPort, "questions service port")
 	flag.Parse()
 
 	question := &pb.CustomerMessage{
 		Body:   "I have a question about this product",
 		Timestamp: time.Now().UnixNano() / 1000000,
-		Sender: &contactpb.Sender{Name: "test1234"},
+		Sender: &pb.Sender{Name: "test1234"},
 	}
 	complaint := &pb.CustomerMessage{
 		Body:   "The knob is too jolly. This does not please me.",
 		Timestamp: time.Now().UnixNano() / 1000000 + 1,
-		Sender: &contactpb.Sender{Name: "test4321"},
+		Sender: &pb.Sender{Name: "test4321"},
 	}
 
 	_ = createMessage(*host, *port, question)
 	_ = createMessage(*host, *port, complaint)
-	_ = getQuestion(*host, *port)
+	_ = getQuestion(*questionsHost, *questionsPort)
 }
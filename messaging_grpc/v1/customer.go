@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	customerspb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+)
+
+// Attempts to find a customer before giving up on concurrent creations
+const maxLinkAttempts = 2
+
+// Finds the customer record of a sender by email address, creating one for
+// unknown senders.
+func (s server) findCustomer(ctx context.Context, sender *pb.Sender) (*customerspb.Customer, error) {
+	fullName := sender.GetName()
+	if fullName == "" {
+		fullName = sender.GetEmail()
+	}
+	sr := &customerspb.SearchCustomersRequest{Email: sender.GetEmail(), PageSize: 1}
+	cr := &customerspb.CreateCustomerRequest{Customer: &customerspb.Customer{FullName: fullName, Email: sender.GetEmail()}}
+
+	for i := 0; i < maxLinkAttempts; i += 1 {
+		var resp *customerspb.SearchCustomersResponse
+		err := s.call(ctx, func(ctx context.Context) (err error) {
+			resp, err = s.customers.SearchCustomers(ctx, sr)
+			return
+		})
+		if err != nil {
+			return nil, err
+		} else if len(resp.GetCustomers()) > 0 {
+			return resp.GetCustomers()[0], nil
+		}
+
+		var c *customerspb.Customer
+		err = s.call(ctx, func(ctx context.Context) (err error) {
+			c, err = s.customers.CreateCustomer(ctx, cr)
+			return
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			// created concurrently; look it up again
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		log.Printf("INFO: created customer %s for '%s'", c.GetName(), sender.GetEmail())
+		return c, nil
+	}
+
+	return nil, status.Errorf(codes.Aborted, "could not resolve customer '%s'", sender.GetEmail())
+}
+
+// Links the message to the customer record of its sender. Linking is best
+// effort: when the customers service fails, the message goes without.
+func (s server) linkCustomer(ctx context.Context, m *pb.CustomerMessage) error {
+	if s.customers == nil || m.GetSender().GetEmail() == "" || m.GetCustomerName() != "" {
+		return nil
+	}
+
+	c, err := s.findCustomer(ctx, m.GetSender())
+	if err != nil && ctx.Err() != nil {
+		return err
+	} else if err != nil {
+		log.Printf("WARN: could not link %s to a customer: %s", m.GetName(), err)
+		return nil
+	}
+	m.CustomerName = c.GetName()
+	m.CustomerTier = c.GetTier()
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	customerspb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+// A customers server that knows customers by email address
+type fakeCustomers struct {
+	customerspb.CustomersClient
+	byEmail map[string]string
+	tiers   map[string]customerspb.Customer_Tier
+	errs    []error
+	created int
+}
+
+func (f *fakeCustomers) pop() error {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	return nil
+}
+
+func (f *fakeCustomers) SearchCustomers(ctx context.Context, r *customerspb.SearchCustomersRequest, _ ...grpc.CallOption) (*customerspb.SearchCustomersResponse, error) {
+	if err := f.pop(); err != nil {
+		return nil, err
+	}
+	resp := &customerspb.SearchCustomersResponse{}
+	if name, ok := f.byEmail[r.GetEmail()]; ok {
+		resp.Customers = append(resp.Customers, &customerspb.Customer{Name: name, Email: r.GetEmail(), Tier: f.tiers[name]})
+	}
+	return resp, nil
+}
+
+func (f *fakeCustomers) CreateCustomer(ctx context.Context, r *customerspb.CreateCustomerRequest, _ ...grpc.CallOption) (*customerspb.Customer, error) {
+	if err := f.pop(); err != nil {
+		return nil, err
+	}
+	c := r.GetCustomer()
+	if _, ok := f.byEmail[c.GetEmail()]; ok {
+		return nil, status.Error(codes.AlreadyExists, "")
+	}
+	f.created += 1
+	f.byEmail[c.GetEmail()] = "customer-" + c.GetEmail()
+	return &customerspb.Customer{Name: f.byEmail[c.GetEmail()], FullName: c.GetFullName(), Email: c.GetEmail()}, nil
+}
+
+func TestServer_LinkCustomer(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "")
+	invalid := status.Error(codes.InvalidArgument, "")
+	cases := []struct {
+		email    string
+		errs     []error
+		expected string
+		created  int
+	}{
+		{"alice@example.com", nil, "customer-alice@example.com", 0},
+		{"bob@example.com", nil, "customer-bob@example.com", 1},
+		{"bob@example.com", []error{unavailable}, "customer-bob@example.com", 1},
+		{"bob@example.com", []error{invalid}, "", 0},
+		{"", nil, "", 0},
+	}
+	for i, c := range cases {
+		customers := &fakeCustomers{byEmail: map[string]string{"alice@example.com": "customer-alice@example.com"}, errs: c.errs}
+		s := newTestServer(storagetest.NewFake(), &fakeCategorising{category: pb.MessageCategory_QUESTION})
+		s.customers = customers
+
+		r := newCreateRequest()
+		r.GetCustomerMessage().Sender = &pb.Sender{Name: "someone", Email: c.email}
+		m, err := s.CreateMessage(context.Background(), r)
+		if err != nil {
+			t.Errorf("case %v: unexpected error %v", i, err)
+			continue
+		}
+		if m.GetCustomerName() != c.expected {
+			t.Errorf("case %v: expected customer '%s', got '%s'", i, c.expected, m.GetCustomerName())
+		}
+		if customers.created != c.created {
+			t.Errorf("case %v: expected %v customers created, got %v", i, c.created, customers.created)
+		}
+	}
+}
+
+func TestServer_Priority(t *testing.T) {
+	customers := &fakeCustomers{
+		byEmail: map[string]string{"alice@example.com": "alice", "bob@example.com": "bob"},
+		tiers:   map[string]customerspb.Customer_Tier{"alice": customerspb.Customer_GOLD},
+	}
+	categorising := &fakeCategorising{category: pb.MessageCategory_QUESTION}
+	s := newTestServer(storagetest.NewFake(), categorising)
+	s.customers = customers
+
+	cases := []struct {
+		email     string
+		timestamp int64
+		priority  int32
+		tier      customerspb.Customer_Tier
+	}{
+		{"bob@example.com", 1000, 10, customerspb.Customer_TIER_UNSPECIFIED},
+		{"alice@example.com", 3000, 60, customerspb.Customer_GOLD},
+		{"bob@example.com", 2000, 60, customerspb.Customer_TIER_UNSPECIFIED},
+	}
+	for i, c := range cases {
+		categorising.priority = c.priority
+		r := newCreateRequest()
+		r.GetCustomerMessage().Sender = &pb.Sender{Name: "someone", Email: c.email}
+		r.GetCustomerMessage().Timestamp = c.timestamp
+		m, err := s.CreateMessage(context.Background(), r)
+		if err != nil {
+			t.Fatalf("case %v: unexpected error %v", i, err)
+		}
+		if categorising.tier != c.tier || m.GetCustomerTier() != c.tier {
+			t.Errorf("case %v: expected tier %v to be categorised and recorded, got %v and %v", i, c.tier, categorising.tier, m.GetCustomerTier())
+		}
+		if m.GetPriority() != c.priority {
+			t.Errorf("case %v: expected priority %v, got %v", i, c.priority, m.GetPriority())
+		}
+	}
+
+	// highest priority first, then oldest
+	for i, expected := range []int64{2000, 3000, 1000} {
+		m, err := s.queues[pb.MessageCategory_QUESTION].Claim(context.Background(), "carol")
+		if err != nil {
+			t.Fatalf("case %v: unexpected error %v", i, err)
+		}
+		if m.GetTimestamp() != expected {
+			t.Errorf("case %v: expected message sent at %v, got %v", i, expected, m.GetTimestamp())
+		}
+	}
+}
@@ -3,5 +3,8 @@ module github.com/HayoVanLoon/protoworkflow/messaging_grpc/v1
 go 1.12
 
 require (
+	github.com/HayoVanLoon/protoworkflow/commons v0.0.0
 	google.golang.org/grpc v1.20.1 // indirect
 )
+
+replace github.com/HayoVanLoon/protoworkflow/commons => ../../commons/v1
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagekey"
+	"github.com/HayoVanLoon/protoworkflow/commons/ulid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+)
+
+// Creates the key under which the message name for a request is recorded
+func requestKey(requestId string) *storagepb.Key {
+	return &storagepb.Key{Parts: []*storagepb.Key_Part{{Key: "request", Value: storagekey.IndexValue(requestId)}}}
+}
+
+// Provides the name for the message of a request. Without a request ID, a
+// new name is generated. Otherwise, the first request records its name in
+// storage and repeated requests get the same name back.
+func (s server) reserveName(ctx context.Context, requestId string) (string, error) {
+	name := ulid.New()
+	if requestId == "" {
+		return name, nil
+	}
+
+	cr := &storagepb.CreateObjectRequest{Key: requestKey(requestId), Data: []byte(name)}
+	err := s.store.Call(ctx, func(ctx context.Context) (err error) {
+		_, err = s.store.Client().CreateObject(ctx, cr)
+		return
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		return name, err
+	}
+
+	gr := &storagepb.GetObjectRequest{Keys: []*storagepb.Key{requestKey(requestId)}, Limit: 1}
+	var resp *storagepb.GetObjectResponse
+	err = s.store.Call(ctx, func(ctx context.Context) (err error) {
+		resp, err = s.store.Client().GetObject(ctx, gr)
+		return
+	})
+	if err != nil {
+		return "", err
+	} else if len(resp.GetEntries()) == 0 {
+		return "", status.Errorf(codes.Aborted, "request %s could not be resolved", requestId)
+	}
+	return string(resp.GetEntries()[0].GetData()), nil
+}
+
+// Stores a new message. When a concurrent repeat of the request stored it
+// first, that message is returned instead. Reports whether this call
+// created the message.
+func (s server) storeNew(ctx context.Context, m *pb.CustomerMessage) (*pb.CustomerMessage, bool, error) {
+	_, err := s.store.Create(ctx, m)
+	if status.Code(err) == codes.AlreadyExists {
+		log.Printf("INFO: %s was stored concurrently", m.GetName())
+		existing, _, err := s.store.Get(ctx, m.GetName())
+		return existing, false, err
+	} else if err != nil {
+		return nil, false, err
+	}
+	return m, true, nil
+}
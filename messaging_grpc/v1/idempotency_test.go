@@ -0,0 +1,114 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestServer_CreateMessageNames(t *testing.T) {
+	s := newTestServer(storagetest.NewFake(), &fakeCategorising{category: pb.MessageCategory_QUESTION})
+
+	// same sender, same timestamp
+	first, err := s.CreateMessage(context.Background(), newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, err := s.CreateMessage(context.Background(), newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.GetName() == second.GetName() {
+		t.Errorf("expected unique names, got %s twice", first.GetName())
+	}
+}
+
+func TestServer_CreateMessageRepeated(t *testing.T) {
+	storage := storagetest.NewFake()
+	categorising := &fakeCategorising{category: pb.MessageCategory_QUESTION}
+	s := newTestServer(storage, categorising)
+
+	r := newCreateRequest()
+	r.RequestId = "abc"
+	first, err := s.CreateMessage(context.Background(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calls := categorising.calls
+
+	r = newCreateRequest()
+	r.RequestId = "abc"
+	second, err := s.CreateMessage(context.Background(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.GetName() != first.GetName() {
+		t.Errorf("expected %s, got %s", first.GetName(), second.GetName())
+	}
+	if categorising.calls != calls {
+		t.Errorf("expected repeated request not to be processed, got %v categorising calls", categorising.calls-calls)
+	}
+
+	r = newCreateRequest()
+	r.RequestId = "def"
+	third, err := s.CreateMessage(context.Background(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if third.GetName() == first.GetName() {
+		t.Errorf("expected new message for a different request")
+	}
+}
+
+func TestServer_CreateMessageLostResponse(t *testing.T) {
+	storage := storagetest.NewFake()
+	s := newTestServer(storage, &fakeCategorising{category: pb.MessageCategory_QUESTION})
+
+	// the request was reserved and the message stored, but the client never
+	// got the response
+	name, err := s.reserveName(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := newTestMessage()
+	m.Name = name
+	if _, err := s.store.Create(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.store.Create(context.Background(), m); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected %v, got %v", codes.AlreadyExists, err)
+	}
+
+	r := newCreateRequest()
+	r.RequestId = "abc"
+	actual, err := s.CreateMessage(context.Background(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual.GetName() != name {
+		t.Errorf("expected %s, got %s", name, actual.GetName())
+	}
+
+	stored, created, err := s.storeNew(context.Background(), m)
+	if err != nil || created || stored.GetName() != name {
+		t.Errorf("expected existing message, got %v, %v, %v", stored, created, err)
+	}
+}
+
+func TestServer_AsyncIngestionRepeated(t *testing.T) {
+	s := newAsyncTestServer(storagetest.NewFake(), &fakeCategorising{category: pb.MessageCategory_QUESTION}, &fakeNotifier{})
+
+	for i := 0; i < 2; i += 1 {
+		r := newCreateRequest()
+		r.RequestId = "abc"
+		if _, err := s.CreateMessage(context.Background(), r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(s.ingest) != 1 {
+		t.Errorf("expected message to be queued once, got %v", len(s.ingest))
+	}
+}
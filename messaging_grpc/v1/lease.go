@@ -0,0 +1,36 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"golang.org/x/net/context"
+)
+
+func (s server) RenewLease(ctx context.Context, r *pb.RenewLeaseRequest) (*pb.CustomerMessage, error) {
+	return s.store.UpdateLeased(ctx, r.GetName(), r.GetAgent(), func(m *pb.CustomerMessage) (*pb.CustomerMessage, error) {
+		return workqueue.WithLease(m, r.GetAgent(), s.leaseDuration), nil
+	})
+}
+
+func (s server) ReleaseMessage(ctx context.Context, r *pb.ReleaseMessageRequest) (*pb.CustomerMessage, error) {
+	return s.store.UpdateLeased(ctx, r.GetName(), r.GetAgent(), func(m *pb.CustomerMessage) (*pb.CustomerMessage, error) {
+		return workqueue.WithoutLease(m), nil
+	})
+}
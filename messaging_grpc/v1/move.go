@@ -0,0 +1,78 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+)
+
+// Creates a copy of the message moved to a new category. The move is recorded
+// and the message is returned to the queue of its new category.
+func withCategory(m *pb.CustomerMessage, cat pb.MessageCategory, agent string, now int64) *pb.CustomerMessage {
+	newM := proto.Clone(m).(*pb.CustomerMessage)
+	newM.Category = cat
+	newM.Status = pb.Status_TO_DO
+	newM.Lease = nil
+	newM.NeedsCategorisation = false
+	newM.Moves = append(newM.Moves, &pb.CategoryMove{
+		OldCategory: m.GetCategory(),
+		NewCategory: cat,
+		Agent:       agent,
+		Timestamp:   now,
+	})
+	return newM
+}
+
+func (s server) MoveMessage(ctx context.Context, r *pb.MoveMessageRequest) (*pb.CustomerMessage, error) {
+	if err := workqueue.ValidateAgent(r.GetAgent()); err != nil {
+		return nil, err
+	}
+	if r.GetOldCategory() == r.GetNewCategory() {
+		return nil, status.Errorf(codes.InvalidArgument, "old and new category are both %s", r.GetNewCategory())
+	}
+
+	m, etag, err := s.store.Get(ctx, r.GetMessageId())
+	if err != nil {
+		return nil, err
+	}
+
+	now := workqueue.NowMillis()
+	if m.GetCategory() != r.GetOldCategory() {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is in %s, not in %s", r.GetMessageId(), m.GetCategory(), r.GetOldCategory())
+	}
+	if claimedByOther(m, r.GetAgent(), now) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is claimed by '%s'", r.GetMessageId(), m.GetLease().GetHolder())
+	}
+
+	newM := withCategory(m, r.GetNewCategory(), r.GetAgent(), now)
+	newEtag, err := s.store.Mutate(ctx, m, newM, etag)
+	if err != nil {
+		return nil, err
+	} else if newEtag == "" {
+		return nil, status.Errorf(codes.Aborted, "concurrent modification of %s", r.GetMessageId())
+	}
+
+	log.Printf("INFO: %s moved from %s to %s by '%s'", r.GetMessageId(), r.GetOldCategory(), r.GetNewCategory(), r.GetAgent())
+	return newM, nil
+}
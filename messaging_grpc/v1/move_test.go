@@ -0,0 +1,36 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"testing"
+	"time"
+)
+
+func TestWithCategory(t *testing.T) {
+	m := workqueue.WithLease(&pb.CustomerMessage{Name: "foo", Category: pb.MessageCategory_FEEDBACK}, "alice", time.Minute)
+
+	moved := withCategory(m, pb.MessageCategory_COMPLAINT, "alice", 42)
+	if moved.GetCategory() != pb.MessageCategory_COMPLAINT {
+		t.Errorf("expected category %s, got %s", pb.MessageCategory_COMPLAINT, moved.GetCategory())
+	}
+	if moved.GetStatus() != pb.Status_TO_DO || moved.GetLease() != nil {
+		t.Errorf("expected message back in queue, got %s with lease %v", moved.GetStatus(), moved.GetLease())
+	}
+	if len(moved.GetMoves()) != 1 {
+		t.Fatalf("expected 1 recorded move, got %v", len(moved.GetMoves()))
+	}
+	mv := moved.GetMoves()[0]
+	if mv.GetOldCategory() != pb.MessageCategory_FEEDBACK || mv.GetNewCategory() != pb.MessageCategory_COMPLAINT ||
+		mv.GetAgent() != "alice" || mv.GetTimestamp() != 42 {
+		t.Errorf("unexpected move record %v", mv)
+	}
+	if m.GetCategory() != pb.MessageCategory_FEEDBACK || len(m.GetMoves()) != 0 {
+		t.Errorf("original message was modified")
+	}
+
+	again := withCategory(moved, pb.MessageCategory_QUESTION, "bob", 43)
+	if len(again.GetMoves()) != 2 || again.GetMoves()[1].GetAgent() != "bob" {
+		t.Errorf("expected moves to accumulate, got %v", again.GetMoves())
+	}
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	defaultIngestionWorkers  = 4
+	defaultIngestionInterval = 10 * time.Second
+	ingestionQueueSize       = 100
+	maxStageAttempts         = 5
+	topicWords               = 5
+)
+
+// A step in the ingestion of a message. A stage updates the message it is
+// given; the updates are stored once the stage has completed.
+type stage struct {
+	name pb.StageStatus_Stage
+	run  func(ctx context.Context, m *pb.CustomerMessage) error
+}
+
+// Announces new messages
+type notifier interface {
+	Notify(ctx context.Context, m *pb.CustomerMessage) error
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Notify(_ context.Context, m *pb.CustomerMessage) error {
+	log.Printf("INFO: new %s %s from '%s'", m.GetCategory(), m.GetName(), m.GetSender().GetName())
+	return nil
+}
+
+// Provides the ingestion stages in order of execution. Enrichment comes
+// first, as the customer tier weighs in on the priority set when
+// categorising.
+func (s server) stages() []stage {
+	return []stage{
+		{pb.StageStatus_ENRICH, s.enrichStage},
+		{pb.StageStatus_CATEGORISE, s.categoriseStage},
+		{pb.StageStatus_INDEX, indexStage},
+		{pb.StageStatus_NOTIFY, s.notifyStage},
+	}
+}
+
+// Sets the message category. When categorising is down, the message is
+// left to the re-categorisation worker rather than holding up the pipeline.
+func (s server) categoriseStage(ctx context.Context, m *pb.CustomerMessage) error {
+	resp, err := s.getCategory(ctx, m)
+	if err != nil && ctx.Err() != nil {
+		return err
+	} else if err != nil {
+		log.Printf("WARN: could not get category for %s: %s", m.GetName(), err)
+		m.Category = pb.MessageCategory_NONE
+		m.NeedsCategorisation = true
+	} else {
+		fileUnder(m, resp, s.uncertainThreshold)
+	}
+	return nil
+}
+
+// Tidies up the message, derives a topic from the body when none was given,
+// records the references in the body and links the sender to a customer.
+func (s server) enrichStage(ctx context.Context, m *pb.CustomerMessage) error {
+	m.Body = strings.TrimSpace(m.GetBody())
+	m.Topic = strings.TrimSpace(m.GetTopic())
+	if m.Topic == "" {
+		ws := strings.Fields(m.GetBody())
+		if len(ws) > topicWords {
+			ws = ws[:topicWords]
+		}
+		m.Topic = strings.Join(ws, " ")
+	}
+	if err := s.extractEntities(ctx, m); err != nil {
+		return err
+	}
+	return s.linkCustomer(ctx, m)
+}
+
+// Indexing takes place when the stage is marked done: from then on the
+// message is stored under its category.
+func indexStage(_ context.Context, _ *pb.CustomerMessage) error {
+	return nil
+}
+
+// Announces the message, unless it was discarded as garbage.
+func (s server) notifyStage(ctx context.Context, m *pb.CustomerMessage) error {
+	if m.GetStatus() == pb.Status_DISCARDED {
+		return nil
+	}
+	return s.notifier.Notify(ctx, m)
+}
+
+// Creates the initial pipeline status for a new message
+func newIngestion(stages []stage, now int64) []*pb.StageStatus {
+	var ss []*pb.StageStatus
+	for _, st := range stages {
+		ss = append(ss, &pb.StageStatus{Stage: st.name, UpdateTime: now})
+	}
+	return ss
+}
+
+// Stores a new message for asynchronous ingestion and queues it.
+func (s server) acceptMessage(ctx context.Context, m *pb.CustomerMessage) (*pb.CustomerMessage, error) {
+	m.Category = pb.MessageCategory_NONE
+	m.Ingestion = newIngestion(s.stages(), workqueue.NowMillis())
+
+	stored, created, err := s.storeNew(ctx, m)
+	if err != nil {
+		log.Printf("ERROR: error while storing message: %s", err)
+		return nil, err
+	} else if !created {
+		return stored, nil
+	}
+
+	select {
+	case s.ingest <- m.GetName():
+	default:
+		log.Printf("WARN: ingestion queue full, %s will be picked up later", m.GetName())
+	}
+	return m, nil
+}
+
+// Runs the pending stages of a message in order. Every completed or failed
+// attempt is stored, so progress survives restarts. Stops at the first
+// stage that errs; it is retried on a later run.
+func (s server) processMessage(ctx context.Context, name string) error {
+	m, etag, err := s.store.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	for _, st := range s.stages() {
+		status := workqueue.FindStage(m, st.name)
+		if status == nil || status.GetState() == pb.StageStatus_DONE {
+			continue
+		} else if status.GetState() == pb.StageStatus_FAILED {
+			return nil
+		}
+
+		newM := proto.Clone(m).(*pb.CustomerMessage)
+		stageErr := st.run(ctx, newM)
+		newStatus := workqueue.FindStage(newM, st.name)
+		newStatus.UpdateTime = workqueue.NowMillis()
+		if stageErr != nil {
+			newStatus.Attempts += 1
+			newStatus.Error = stageErr.Error()
+			if newStatus.Attempts >= maxStageAttempts {
+				newStatus.State = pb.StageStatus_FAILED
+				log.Printf("ERROR: %s failed in %s: %s", name, st.name, stageErr)
+			}
+		} else {
+			newStatus.State = pb.StageStatus_DONE
+			newStatus.Error = ""
+		}
+
+		newEtag, err := s.store.Mutate(ctx, m, newM, etag)
+		if err != nil {
+			return err
+		} else if newEtag == "" {
+			// someone else is working on the message
+			return nil
+		} else if stageErr != nil {
+			return stageErr
+		}
+		m, etag = newM, newEtag
+	}
+	return nil
+}
+
+// Queues all messages with unfinished ingestions
+func (s server) requeuePending(ctx context.Context) {
+	query := &storagepb.Key{
+		IndexedValues: []*storagepb.Key_Part{{Key: "ingestion", Value: pb.StageStatus_PENDING.String()}},
+	}
+	msgs, _, err := s.store.Query(ctx, []*storagepb.Key{query}, 0)
+	if err != nil {
+		log.Printf("WARN: error retrieving pending ingestions: %s", err)
+		return
+	}
+	for _, m := range msgs {
+		select {
+		case s.ingest <- m.GetName():
+		default:
+			return
+		}
+	}
+}
+
+// Starts the ingestion workers and periodically requeues unfinished
+// ingestions, such as those interrupted by a restart.
+func (s server) runIngestion(workers int, interval time.Duration) {
+	for i := 0; i < workers; i += 1 {
+		go func() {
+			for name := range s.ingest {
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				if err := s.processMessage(ctx, name); err != nil {
+					log.Printf("WARN: ingestion of %s interrupted: %s", name, err)
+				}
+				cancel()
+			}
+		}()
+	}
+	go func() {
+		for range time.Tick(interval) {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			s.requeuePending(ctx)
+			cancel()
+		}
+	}()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+type fakeNotifier struct {
+	errs     []error
+	notified []string
+}
+
+func (f *fakeNotifier) Notify(_ context.Context, m *pb.CustomerMessage) error {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	f.notified = append(f.notified, m.GetName())
+	return nil
+}
+
+func newAsyncTestServer(storage *storagetest.Fake, categorising *fakeCategorising, n notifier) *server {
+	s := newTestServer(storage, categorising)
+	s.ingest = make(chan string, 10)
+	s.notifier = n
+	return s
+}
+
+func TestIngestionState(t *testing.T) {
+	pending := &pb.StageStatus{State: pb.StageStatus_PENDING}
+	done := &pb.StageStatus{State: pb.StageStatus_DONE}
+	failed := &pb.StageStatus{State: pb.StageStatus_FAILED}
+	cases := []struct {
+		stages   []*pb.StageStatus
+		expected pb.StageStatus_State
+	}{
+		{nil, pb.StageStatus_DONE},
+		{[]*pb.StageStatus{done, done}, pb.StageStatus_DONE},
+		{[]*pb.StageStatus{done, pending}, pb.StageStatus_PENDING},
+		{[]*pb.StageStatus{done, failed, pending}, pb.StageStatus_FAILED},
+	}
+	for i, c := range cases {
+		if actual := workqueue.IngestionState(&pb.CustomerMessage{Ingestion: c.stages}); actual != c.expected {
+			t.Errorf("case %v: expected %s, got %s", i, c.expected, actual)
+		}
+	}
+}
+
+func TestEnrichStage(t *testing.T) {
+	cases := []struct {
+		topic, body string
+		expected    string
+	}{
+		{"knobs", "Do you sell knobs?", "knobs"},
+		{"", "  Do you sell knobs?", "Do you sell knobs?"},
+		{" ", "Do you sell knobs in red and blue?", "Do you sell knobs in"},
+	}
+	entities := []*pb.Entity{{Type: pb.Entity_PRODUCT, Value: "jolly-knob"}}
+	s := newTestServer(storagetest.NewFake(), &fakeCategorising{entities: entities})
+	for i, c := range cases {
+		m := &pb.CustomerMessage{Topic: c.topic, Body: c.body}
+		if err := s.enrichStage(context.Background(), m); err != nil {
+			t.Errorf("case %v: unexpected error %v", i, err)
+		}
+		if m.GetTopic() != c.expected {
+			t.Errorf("case %v: expected topic '%s', got '%s'", i, c.expected, m.GetTopic())
+		}
+		if len(m.GetEntities()) != 1 {
+			t.Errorf("case %v: expected entities to be recorded, got %v", i, m.GetEntities())
+		}
+	}
+
+	unavailable := status.Error(codes.Unavailable, "")
+	s = newTestServer(storagetest.NewFake(), &fakeCategorising{errs: []error{unavailable, unavailable, unavailable}})
+	m := &pb.CustomerMessage{Body: "Do you sell knobs?"}
+	if err := s.enrichStage(context.Background(), m); err != nil || len(m.GetEntities()) != 0 {
+		t.Errorf("expected enrichment without entities, got %v, %v", err, m.GetEntities())
+	}
+}
+
+func TestServer_AsyncIngestion(t *testing.T) {
+	storage := storagetest.NewFake()
+	categorising := &fakeCategorising{category: pb.MessageCategory_QUESTION}
+	n := &fakeNotifier{}
+	s := newAsyncTestServer(storage, categorising, n)
+
+	m, err := s.CreateMessage(context.Background(), newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.GetName() == "" || m.GetCategory() != pb.MessageCategory_NONE || len(m.GetIngestion()) != 4 {
+		t.Errorf("expected accepted message, got %v", m)
+	}
+	if categorising.calls != 0 {
+		t.Errorf("expected categorising to be deferred")
+	}
+	if name := <-s.ingest; name != m.GetName() {
+		t.Errorf("expected %s to be queued, got %s", m.GetName(), name)
+	}
+
+	// not claimable before it has been indexed
+	if _, err := s.queues[pb.MessageCategory_QUESTION].Claim(context.Background(), ""); err == nil {
+		t.Errorf("expected no questions before ingestion")
+	}
+
+	if err := s.processMessage(context.Background(), m.GetName()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	actual, _ := s.GetMessage(context.Background(), &pb.GetMessageRequest{Name: m.GetName()})
+	if actual.GetCategory() != pb.MessageCategory_QUESTION || workqueue.IngestionState(actual) != pb.StageStatus_DONE {
+		t.Errorf("expected ingested question, got %v", actual)
+	}
+	if len(n.notified) != 1 {
+		t.Errorf("expected one notification, got %v", n.notified)
+	}
+	if q, err := s.queues[pb.MessageCategory_QUESTION].Claim(context.Background(), "alice"); err != nil || q.GetName() != m.GetName() {
+		t.Errorf("expected question after ingestion, got %v, %v", q, err)
+	}
+}
+
+func TestServer_IngestionRetries(t *testing.T) {
+	var errs []error
+	for i := 0; i < maxStageAttempts; i += 1 {
+		errs = append(errs, errors.New("no route to inbox"))
+	}
+	storage := storagetest.NewFake()
+	n := &fakeNotifier{errs: errs}
+	s := newAsyncTestServer(storage, &fakeCategorising{category: pb.MessageCategory_FEEDBACK}, n)
+
+	m, err := s.CreateMessage(context.Background(), newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 1; i <= maxStageAttempts; i += 1 {
+		if err := s.processMessage(context.Background(), m.GetName()); err == nil {
+			t.Fatalf("attempt %v: expected error", i)
+		}
+		actual, _ := s.GetMessage(context.Background(), &pb.GetMessageRequest{Name: m.GetName()})
+		st := workqueue.FindStage(actual, pb.StageStatus_NOTIFY)
+		if st.GetAttempts() != int32(i) || st.GetError() == "" {
+			t.Errorf("attempt %v: expected attempt and error to be recorded, got %v", i, st)
+		}
+		if workqueue.FindStage(actual, pb.StageStatus_INDEX).GetState() != pb.StageStatus_DONE {
+			t.Errorf("attempt %v: expected earlier stages to be done", i)
+		}
+		expected := pb.StageStatus_PENDING
+		if i == maxStageAttempts {
+			expected = pb.StageStatus_FAILED
+		}
+		if st.GetState() != expected {
+			t.Errorf("attempt %v: expected %s, got %s", i, expected, st.GetState())
+		}
+	}
+
+	if err := s.processMessage(context.Background(), m.GetName()); err != nil {
+		t.Errorf("expected failed ingestion to be left alone, got %v", err)
+	}
+}
+
+func TestServer_AsyncIngestionGarbage(t *testing.T) {
+	storage := storagetest.NewFake()
+	n := &fakeNotifier{}
+	s := newAsyncTestServer(storage, &fakeCategorising{category: pb.MessageCategory_GARBAGE}, n)
+
+	m, err := s.CreateMessage(context.Background(), newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-s.ingest
+	if err := s.processMessage(context.Background(), m.GetName()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	actual, _ := s.GetMessage(context.Background(), &pb.GetMessageRequest{Name: m.GetName()})
+	if actual.GetCategory() != pb.MessageCategory_GARBAGE || actual.GetStatus() != pb.Status_DISCARDED {
+		t.Errorf("expected discarded garbage, got %v", actual)
+	}
+	if len(n.notified) != 0 {
+		t.Errorf("expected no notification for garbage, got %v", n.notified)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	categorisingpb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"log"
+	"time"
+)
+
+const defaultRecategoriseInterval = time.Minute
+
+// Creates a copy of the message, filed where it should have been on arrival
+func withRecategorisation(m *pb.CustomerMessage, resp *categorisingpb.GetCategoryResponse, threshold float32) *pb.CustomerMessage {
+	newM := proto.Clone(m).(*pb.CustomerMessage)
+	fileUnder(newM, resp, threshold)
+	newM.NeedsCategorisation = false
+	return newM
+}
+
+// Categorises messages that were stored while categorising was down, in one
+// batch. Returns the number of messages categorised.
+func (s server) recategorise(ctx context.Context) int {
+	query := &storagepb.Key{
+		IndexedValues: []*storagepb.Key_Part{{Key: "needs_categorisation", Value: "true"}},
+	}
+	todo, todoEtags, err := s.store.Query(ctx, []*storagepb.Key{query}, messageLimit)
+	if err != nil {
+		log.Printf("WARN: error retrieving uncategorised messages: %s", err)
+		return 0
+	}
+	if len(todo) == 0 {
+		return 0
+	}
+
+	resps, err := s.getCategories(ctx, todo)
+	if err != nil {
+		log.Printf("WARN: still unable to categorise messages: %s", err)
+		return 0
+	}
+
+	done := 0
+	for i, m := range todo {
+		newM := withRecategorisation(m, resps[i], s.uncertainThreshold)
+		newEtag, err := s.store.Mutate(ctx, m, newM, todoEtags[i])
+		if err != nil {
+			break
+		} else if newEtag != "" {
+			log.Printf("INFO: %s categorised as %s", m.GetName(), newM.GetCategory())
+			done += 1
+		}
+	}
+	return done
+}
+
+// Periodically categorises messages stored while categorising was down.
+func (s server) recategoriseLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		s.recategorise(ctx)
+		cancel()
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestServer_CreateMessageDegraded(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "")
+	storage := storagetest.NewFake()
+	categorising := &fakeCategorising{
+		category: pb.MessageCategory_QUESTION,
+		errs:     []error{unavailable, unavailable, unavailable},
+	}
+	s := newTestServer(storage, categorising)
+
+	m, err := s.CreateMessage(context.Background(), newCreateRequest())
+	if err != nil {
+		t.Fatalf("expected message to be stored, got %v", err)
+	}
+	if m.GetCategory() != pb.MessageCategory_NONE || !m.GetNeedsCategorisation() {
+		t.Errorf("expected uncategorised message, got %v", m)
+	}
+
+	if n := s.recategorise(context.Background()); n != 1 {
+		t.Errorf("expected 1 message categorised, got %v", n)
+	}
+	actual, err := s.GetMessage(context.Background(), &pb.GetMessageRequest{Name: m.GetName()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual.GetCategory() != pb.MessageCategory_QUESTION || actual.GetNeedsCategorisation() {
+		t.Errorf("expected categorised message, got %v", actual)
+	}
+	if n := s.recategorise(context.Background()); n != 0 {
+		t.Errorf("expected nothing left to categorise, got %v", n)
+	}
+}
+
+func TestServer_recategoriseBatch(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "")
+	categorising := &fakeCategorising{category: pb.MessageCategory_FEEDBACK}
+	s := newTestServer(storagetest.NewFake(), categorising)
+
+	for i := 0; i < 3; i += 1 {
+		categorising.errs = []error{unavailable, unavailable, unavailable}
+		m := newCreateRequest()
+		m.GetCustomerMessage().Timestamp += int64(i)
+		if _, err := s.CreateMessage(context.Background(), m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if n := s.recategorise(context.Background()); n != 3 {
+		t.Errorf("expected 3 messages categorised, got %v", n)
+	}
+	if categorising.batches != 1 {
+		t.Errorf("expected a single batch, got %v", categorising.batches)
+	}
+}
+
+func TestServer_recategoriseSkipsOtherUncategorised(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "")
+	categorising := &fakeCategorising{category: pb.MessageCategory_FEEDBACK}
+	s := newTestServer(storagetest.NewFake(), categorising)
+	ctx := context.Background()
+
+	// uncategorised because their ingestion is still pending
+	for i := 0; i < 5*messageLimit; i += 1 {
+		m := &pb.CustomerMessage{Name: fmt.Sprintf("pending%d", i), Category: pb.MessageCategory_NONE}
+		if _, err := s.store.Create(ctx, m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	categorising.errs = []error{unavailable, unavailable, unavailable}
+	if _, err := s.CreateMessage(ctx, newCreateRequest()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n := s.recategorise(ctx); n != 1 {
+		t.Errorf("expected 1 message categorised, got %v", n)
+	}
+}
+
+func TestServer_CategorisingBreaker(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "")
+	var errs []error
+	for i := 0; i < 100; i += 1 {
+		errs = append(errs, unavailable)
+	}
+	categorising := &fakeCategorising{category: pb.MessageCategory_QUESTION, errs: errs}
+	s := newTestServer(storagetest.NewFake(), categorising)
+
+	for i := 0; i < 10; i += 1 {
+		m := newCreateRequest()
+		m.GetCustomerMessage().Timestamp += int64(i)
+		if _, err := s.CreateMessage(context.Background(), m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the breaker opens after five failed categorisations of three attempts
+	if categorising.calls != 15 {
+		t.Errorf("expected categorising to be skipped once the circuit opened, got %v calls", categorising.calls)
+	}
+	if n := s.recategorise(context.Background()); n != 0 {
+		t.Errorf("expected nothing categorised while the circuit is open, got %v", n)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagekey"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+	// the most storage queries a search may expand to
+	maxSearchKeys = 100
+)
+
+// Expands one index filter over a set of (partial) queries
+func expand(keys []*storagepb.Key, k string, vs []string) []*storagepb.Key {
+	if len(vs) == 0 {
+		return keys
+	}
+	var result []*storagepb.Key
+	for _, key := range keys {
+		for _, v := range vs {
+			ivs := append(append([]*storagepb.Key_Part{}, key.IndexedValues...), &storagepb.Key_Part{Key: k, Value: v})
+			result = append(result, &storagepb.Key{IndexedValues: ivs})
+		}
+	}
+	return result
+}
+
+// Translates a search request into storage queries. The index filters are
+// combined into one query per combination of values; the union of their
+// results is a superset of the messages matching the request. Fails with
+// InvalidArgument when there are too many combinations or no indexed filter.
+func searchKeys(r *pb.SearchMessagesRequest) ([]*storagepb.Key, error) {
+	var cats, sts, senders, topics, products, orders, emails []string
+	for _, c := range r.GetCategories() {
+		cats = append(cats, c.String())
+	}
+	for _, st := range r.GetStatus() {
+		sts = append(sts, st.String())
+	}
+	for _, s := range r.GetSenders() {
+		senders = append(senders, storagekey.IndexValue(s))
+	}
+	for _, t := range r.GetTopics() {
+		topics = append(topics, storagekey.IndexValue(t))
+	}
+	for _, p := range r.GetProducts() {
+		products = append(products, storagekey.IndexValue(p))
+	}
+	for _, o := range r.GetOrderNumbers() {
+		orders = append(orders, storagekey.IndexValue(o))
+	}
+	for _, e := range r.GetEmails() {
+		emails = append(emails, storagekey.IndexValue(strings.ToLower(e)))
+	}
+
+	filters := [][]string{cats, sts, senders, topics, products, orders, emails}
+	n := 1
+	indexed := false
+	for _, vs := range filters {
+		if len(vs) > 0 {
+			n *= len(vs)
+			indexed = true
+		}
+		if n > maxSearchKeys {
+			return nil, status.Errorf(codes.InvalidArgument, "filters combine into more than %d queries", maxSearchKeys)
+		}
+	}
+
+	if !indexed {
+		if len(r.GetNames()) == 0 {
+			// a time range alone would require a full scan
+			return nil, status.Error(codes.InvalidArgument, "time range requires another filter")
+		}
+		if len(r.GetNames()) > maxSearchKeys {
+			return nil, status.Errorf(codes.InvalidArgument, "more than %d names", maxSearchKeys)
+		}
+		var keys []*storagepb.Key
+		for _, n := range r.GetNames() {
+			keys = append(keys, workqueue.MessageKey(n))
+		}
+		return keys, nil
+	}
+
+	keys := []*storagepb.Key{{}}
+	keys = expand(keys, "category", cats)
+	keys = expand(keys, "status", sts)
+	keys = expand(keys, "sender", senders)
+	keys = expand(keys, "topic", topics)
+	keys = expand(keys, workqueue.EntityIndex[pb.Entity_PRODUCT], products)
+	keys = expand(keys, workqueue.EntityIndex[pb.Entity_ORDER_NUMBER], orders)
+	keys = expand(keys, workqueue.EntityIndex[pb.Entity_EMAIL], emails)
+	return keys, nil
+}
+
+func containsString(xs []string, x string) bool {
+	for _, y := range xs {
+		if x == y {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the message mentions one of the values as an entity of the
+// given type
+func mentions(m *pb.CustomerMessage, t pb.Entity_Type, vs []string) bool {
+	for _, e := range m.GetEntities() {
+		if e.GetType() == t && containsString(vs, e.GetValue()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a message passes all filters of the request
+func matches(m *pb.CustomerMessage, r *pb.SearchMessagesRequest) bool {
+	if len(r.GetNames()) > 0 && !containsString(r.GetNames(), m.GetName()) {
+		return false
+	}
+	if len(r.GetCategories()) > 0 {
+		found := false
+		for _, c := range r.GetCategories() {
+			found = found || c == m.GetCategory()
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.GetStatus()) > 0 {
+		found := false
+		for _, st := range r.GetStatus() {
+			found = found || st == m.GetStatus()
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.GetSenders()) > 0 && !containsString(r.GetSenders(), m.GetSender().GetName()) {
+		return false
+	}
+	if len(r.GetTopics()) > 0 && !containsString(r.GetTopics(), m.GetTopic()) {
+		return false
+	}
+	if len(r.GetProducts()) > 0 && !mentions(m, pb.Entity_PRODUCT, r.GetProducts()) {
+		return false
+	}
+	if len(r.GetOrderNumbers()) > 0 && !mentions(m, pb.Entity_ORDER_NUMBER, r.GetOrderNumbers()) {
+		return false
+	}
+	if len(r.GetEmails()) > 0 {
+		var emails []string
+		for _, e := range r.GetEmails() {
+			emails = append(emails, strings.ToLower(e))
+		}
+		if !mentions(m, pb.Entity_EMAIL, emails) {
+			return false
+		}
+	}
+	if r.GetStartTime() != 0 && m.GetTimestamp() < r.GetStartTime() {
+		return false
+	}
+	if r.GetEndTime() != 0 && m.GetTimestamp() >= r.GetEndTime() {
+		return false
+	}
+	return true
+}
+
+// Reports whether message a comes before message b in the given order
+func before(a, b *pb.CustomerMessage, order pb.SearchMessagesRequest_SortOrder) bool {
+	if a.GetTimestamp() != b.GetTimestamp() {
+		if order == pb.SearchMessagesRequest_NEWEST_FIRST {
+			return a.GetTimestamp() > b.GetTimestamp()
+		}
+		return a.GetTimestamp() < b.GetTimestamp()
+	}
+	return a.GetName() < b.GetName()
+}
+
+// A page token marks the last message of the previous page
+func encodePageToken(m *pb.CustomerMessage) string {
+	s := strconv.FormatInt(m.GetTimestamp(), 10) + "/" + m.GetName()
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func decodePageToken(token string) (*pb.CustomerMessage, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token")
+	}
+	ss := strings.SplitN(string(bs), "/", 2)
+	if len(ss) != 2 {
+		return nil, fmt.Errorf("invalid page token")
+	}
+	ts, err := strconv.ParseInt(ss[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token")
+	}
+	return &pb.CustomerMessage{Timestamp: ts, Name: ss[1]}, nil
+}
+
+// Sorts the messages and cuts out the requested page
+func paginate(msgs []*pb.CustomerMessage, r *pb.SearchMessagesRequest) ([]*pb.CustomerMessage, string, error) {
+	order := r.GetOrder()
+	sort.Slice(msgs, func(i, j int) bool {
+		return before(msgs[i], msgs[j], order)
+	})
+
+	if r.GetPageToken() != "" {
+		last, err := decodePageToken(r.GetPageToken())
+		if err != nil {
+			return nil, "", err
+		}
+		i := sort.Search(len(msgs), func(i int) bool {
+			return before(last, msgs[i], order)
+		})
+		msgs = msgs[i:]
+	}
+
+	size := int(r.GetPageSize())
+	if size <= 0 {
+		size = defaultPageSize
+	} else if size > maxPageSize {
+		size = maxPageSize
+	}
+	if len(msgs) <= size {
+		return msgs, "", nil
+	}
+	return msgs[:size], encodePageToken(msgs[size-1]), nil
+}
+
+func (s server) SearchMessages(ctx context.Context, r *pb.SearchMessagesRequest) (*pb.SearchMessagesResponse, error) {
+	if len(r.GetNames())+len(r.GetCategories())+len(r.GetStatus())+len(r.GetSenders())+len(r.GetTopics())+
+		len(r.GetProducts())+len(r.GetOrderNumbers())+len(r.GetEmails()) == 0 &&
+		r.GetStartTime() == 0 && r.GetEndTime() == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no search filters")
+	}
+
+	keys, err := searchKeys(r)
+	if err != nil {
+		return nil, err
+	}
+	found, _, err := s.store.Query(ctx, keys, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []*pb.CustomerMessage
+	for _, m := range found {
+		if matches(m, r) {
+			msgs = append(msgs, m)
+		}
+	}
+
+	page, next, err := paginate(msgs, r)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &pb.SearchMessagesResponse{CustomerMessages: page, NextPageToken: next}, nil
+}
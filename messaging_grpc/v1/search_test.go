@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestSearchKeys(t *testing.T) {
+	cases := []struct {
+		r        *pb.SearchMessagesRequest
+		expected []string
+	}{
+		{
+			&pb.SearchMessagesRequest{Names: []string{"a", "b"}},
+			[]string{"id=a", "id=b"},
+		},
+		{
+			&pb.SearchMessagesRequest{Status: []pb.Status{pb.Status_TO_DO}},
+			[]string{"status=TO_DO"},
+		},
+		{
+			&pb.SearchMessagesRequest{
+				Categories: []pb.MessageCategory{pb.MessageCategory_QUESTION, pb.MessageCategory_COMPLAINT},
+				Status:     []pb.Status{pb.Status_TO_DO, pb.Status_ON_HOLD},
+			},
+			[]string{
+				"category=QUESTION,status=TO_DO", "category=QUESTION,status=ON_HOLD",
+				"category=COMPLAINT,status=TO_DO", "category=COMPLAINT,status=ON_HOLD",
+			},
+		},
+		{
+			&pb.SearchMessagesRequest{Senders: []string{"x=y"}, Topics: []string{"knobs"}},
+			[]string{"sender=x%3Dy,topic=knobs"},
+		},
+		{
+			&pb.SearchMessagesRequest{Names: []string{"a"}, Topics: []string{"knobs"}},
+			[]string{"topic=knobs"},
+		},
+		{
+			&pb.SearchMessagesRequest{Products: []string{"jolly-knob"}, OrderNumbers: []string{"1", "2"}, Emails: []string{"Bob@Example.com"}},
+			[]string{
+				"entity_product=jolly-knob,entity_order=1,entity_email=bob@example.com",
+				"entity_product=jolly-knob,entity_order=2,entity_email=bob@example.com",
+			},
+		},
+	}
+	for i, c := range cases {
+		keys, err := searchKeys(c.r)
+		if err != nil {
+			t.Errorf("case %v: unexpected error %v", i, err)
+			continue
+		}
+		var actual []string
+		for _, k := range keys {
+			s := ""
+			for j, p := range append(k.GetParts(), k.GetIndexedValues()...) {
+				if j > 0 {
+					s += ","
+				}
+				s += p.GetKey() + "=" + p.GetValue()
+			}
+			actual = append(actual, s)
+		}
+		if len(actual) != len(c.expected) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+			continue
+		}
+		for j := range actual {
+			if actual[j] != c.expected[j] {
+				t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchKeys_Invalid(t *testing.T) {
+	many := func(n int) []string {
+		var vs []string
+		for i := 0; i < n; i += 1 {
+			vs = append(vs, fmt.Sprint(i))
+		}
+		return vs
+	}
+	cases := []*pb.SearchMessagesRequest{
+		{StartTime: 1000},
+		{StartTime: 1000, EndTime: 2000},
+		{Names: many(maxSearchKeys + 1)},
+		{Topics: many(maxSearchKeys + 1)},
+		{Topics: many(20), Senders: many(6)},
+		{Topics: many(10), Senders: many(10), Products: many(2)},
+	}
+	for i, r := range cases {
+		if _, err := searchKeys(r); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("case %v: expected %v, got %v", i, codes.InvalidArgument, err)
+		}
+	}
+
+	r := &pb.SearchMessagesRequest{Topics: many(10), Senders: many(10), StartTime: 1000}
+	if keys, err := searchKeys(r); err != nil || len(keys) != maxSearchKeys {
+		t.Errorf("expected %v keys, got %v, %v", maxSearchKeys, len(keys), err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	m := &pb.CustomerMessage{
+		Name:      "foo",
+		Sender:    &pb.Sender{Name: "alice"},
+		Topic:     "knobs",
+		Timestamp: 1000,
+		Category:  pb.MessageCategory_QUESTION,
+		Status:    pb.Status_TO_DO,
+		Entities: []*pb.Entity{
+			{Type: pb.Entity_PRODUCT, Value: "jolly-knob"},
+			{Type: pb.Entity_ORDER_NUMBER, Value: "123456"},
+			{Type: pb.Entity_EMAIL, Value: "bob@example.com"},
+		},
+	}
+	cases := []struct {
+		r        *pb.SearchMessagesRequest
+		expected bool
+	}{
+		{&pb.SearchMessagesRequest{Names: []string{"foo"}}, true},
+		{&pb.SearchMessagesRequest{Names: []string{"bar"}}, false},
+		{&pb.SearchMessagesRequest{Categories: []pb.MessageCategory{pb.MessageCategory_COMPLAINT, pb.MessageCategory_QUESTION}}, true},
+		{&pb.SearchMessagesRequest{Categories: []pb.MessageCategory{pb.MessageCategory_COMPLAINT}}, false},
+		{&pb.SearchMessagesRequest{Status: []pb.Status{pb.Status_TO_DO}}, true},
+		{&pb.SearchMessagesRequest{Status: []pb.Status{pb.Status_DONE}}, false},
+		{&pb.SearchMessagesRequest{Senders: []string{"alice"}}, true},
+		{&pb.SearchMessagesRequest{Senders: []string{"bob"}}, false},
+		{&pb.SearchMessagesRequest{Topics: []string{"knobs"}}, true},
+		{&pb.SearchMessagesRequest{Topics: []string{"dials"}}, false},
+		{&pb.SearchMessagesRequest{StartTime: 1000, EndTime: 1001}, true},
+		{&pb.SearchMessagesRequest{StartTime: 1001}, false},
+		{&pb.SearchMessagesRequest{EndTime: 1000}, false},
+		{&pb.SearchMessagesRequest{Names: []string{"foo"}, Status: []pb.Status{pb.Status_DONE}}, false},
+		{&pb.SearchMessagesRequest{Products: []string{"brass-knob", "jolly-knob"}}, true},
+		{&pb.SearchMessagesRequest{Products: []string{"brass-knob"}}, false},
+		{&pb.SearchMessagesRequest{Products: []string{"123456"}}, false},
+		{&pb.SearchMessagesRequest{OrderNumbers: []string{"123456"}}, true},
+		{&pb.SearchMessagesRequest{OrderNumbers: []string{"654321"}}, false},
+		{&pb.SearchMessagesRequest{Emails: []string{"BOB@example.com"}}, true},
+		{&pb.SearchMessagesRequest{Emails: []string{"alice@example.com"}}, false},
+	}
+	for i, c := range cases {
+		if actual := matches(m, c.r); actual != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	newMsgs := func() []*pb.CustomerMessage {
+		return []*pb.CustomerMessage{
+			{Name: "c", Timestamp: 3},
+			{Name: "a", Timestamp: 1},
+			{Name: "b2", Timestamp: 2},
+			{Name: "b1", Timestamp: 2},
+			{Name: "d", Timestamp: 4},
+		}
+	}
+	names := func(msgs []*pb.CustomerMessage) string {
+		s := ""
+		for _, m := range msgs {
+			s += m.GetName() + " "
+		}
+		return s
+	}
+
+	cases := []struct {
+		order    pb.SearchMessagesRequest_SortOrder
+		size     int32
+		expected []string
+	}{
+		{pb.SearchMessagesRequest_OLDEST_FIRST, 0, []string{"a b1 b2 c d "}},
+		{pb.SearchMessagesRequest_OLDEST_FIRST, 2, []string{"a b1 ", "b2 c ", "d "}},
+		{pb.SearchMessagesRequest_NEWEST_FIRST, 2, []string{"d c ", "b1 b2 ", "a "}},
+		{pb.SearchMessagesRequest_NEWEST_FIRST, 5, []string{"d c b1 b2 a "}},
+	}
+	for i, c := range cases {
+		r := &pb.SearchMessagesRequest{Order: c.order, PageSize: c.size}
+		for j, exp := range c.expected {
+			page, next, err := paginate(newMsgs(), r)
+			if err != nil {
+				t.Fatalf("case %v: unexpected error %v", i, err)
+			}
+			if actual := names(page); actual != exp {
+				t.Errorf("case %v, page %v: expected %v, got %v", i, j, exp, actual)
+			}
+			if (next == "") != (j == len(c.expected)-1) {
+				t.Errorf("case %v, page %v: unexpected next page token '%s'", i, j, next)
+			}
+			r.PageToken = next
+		}
+	}
+
+	if _, _, err := paginate(newMsgs(), &pb.SearchMessagesRequest{PageToken: "garbage"}); err == nil {
+		t.Errorf("expected error on invalid page token")
+	}
+}
@@ -19,362 +19,281 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	categorisingpb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	customerspb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
 	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
 	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
-	"github.com/golang/protobuf/proto"
+	"github.com/HayoVanLoon/protoworkflow/commons/breaker"
+	"github.com/HayoVanLoon/protoworkflow/commons/clients"
+	"github.com/HayoVanLoon/protoworkflow/commons/measure"
+	"github.com/HayoVanLoon/protoworkflow/commons/retry"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
 	"github.com/golang/protobuf/ptypes/empty"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 	"log"
 	"net"
-	"strconv"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 const (
 	storageService      = "storage-service"
 	categorisingService = "categorising-service"
+	customersService    = "customers-service"
 	defaultPort         = "8080"
-	maxRetries          = 3
 	messageLimit        = 10
+	callTimeout         = 10 * time.Second
 )
 
 type server struct {
-	services map[string]string
+	store         *workqueue.Store
+	queues        map[pb.MessageCategory]*workqueue.Queue
+	categorising  categorisingpb.CategorisingClient
+	leaseDuration time.Duration
+	retry         retry.Policy
+	// guards the categorising service
+	breaker *breaker.Breaker
+	// names of messages to ingest; nil unless ingestion is asynchronous
+	ingest   chan string
+	notifier notifier
+	// links senders to customer records; nil to leave messages unlinked
+	customers customerspb.CustomersClient
+	// categorisations with a lower confidence are sent to triage
+	uncertainThreshold float32
 }
 
-func newServer(services map[string]string) *server {
-	return &server{services}
+func newServer(storage storagepb.StorageClient, categorising categorisingpb.CategorisingClient, leaseDuration time.Duration, policy retry.Policy) *server {
+	store := workqueue.NewStore(storage, policy)
+	queues := make(map[pb.MessageCategory]*workqueue.Queue)
+	for _, cat := range []pb.MessageCategory{
+		pb.MessageCategory_QUESTION,
+		pb.MessageCategory_COMPLAINT,
+		pb.MessageCategory_FEEDBACK,
+		pb.MessageCategory_TRIAGE,
+	} {
+		queues[cat] = workqueue.NewQueue(store, cat, leaseDuration)
+	}
+	return &server{
+		store,
+		queues,
+		categorising,
+		leaseDuration,
+		policy,
+		breaker.New(categorisingService, 0, 0),
+		nil,
+		logNotifier{},
+		nil,
+		0,
+	}
 }
 
-// Provides a storage client
-func (s server) getStorageClient() (storagepb.StorageClient, func(), error) {
-	conn, err := s.getConn(storageService)
+// Creates a server on top of the shared connections of the client manager
+func newServerFromManager(m *clients.Manager, leaseDuration time.Duration) (*server, error) {
+	storageConn, err := m.Conn(storageService)
 	if err != nil {
-		log.Print("ERROR: could not open connection to storage")
-		return nil, nil, err
+		return nil, err
 	}
-	return storagepb.NewStorageClient(conn), closeConnFn(conn), err
-}
-
-// Provides a connection-closing function
-func closeConnFn(conn *grpc.ClientConn) func() {
-	return func() {
-		if err := conn.Close(); err != nil {
-			log.Printf("WARN: error closing connection: %v", err)
-		}
+	categorisingConn, err := m.Conn(categorisingService)
+	if err != nil {
+		return nil, err
 	}
-}
-
-// Establishes a connection to a service
-func (s server) getConn(service string) (*grpc.ClientConn, error) {
-	conn, err := grpc.Dial(s.services[service], grpc.WithInsecure())
+	customersConn, err := m.Conn(customersService)
 	if err != nil {
-		return nil, fmt.Errorf("did not connect: %v", err)
+		return nil, err
 	}
-	return conn, nil
+	s := newServer(
+		storagepb.NewStorageClient(storageConn),
+		categorisingpb.NewCategorisingClient(categorisingConn),
+		leaseDuration,
+		retry.DefaultPolicy(),
+	)
+	s.customers = customerspb.NewCustomersClient(customersConn)
+	return s, nil
 }
 
-// Creates a key for the message
-func createKey(m *pb.CustomerMessage) *storagepb.Key {
-	return &storagepb.Key{
-		Parts: []*storagepb.Key_Part{
-			{Key: "timestamp", Value: strconv.FormatInt(m.Timestamp, 10)},
-			{Key: "id", Value: m.Sender.Name},
-		},
-		IndexedValues: []*storagepb.Key_Part{
-			{Key: "category", Value: m.GetCategory().String()},
-			{Key: "status", Value: m.GetStatus().String()},
-		},
-	}
+// Performs a call to another service, retrying it according to the retry
+// policy. Every attempt is limited to callTimeout, within the deadline of the
+// context.
+func (s server) call(ctx context.Context, fn func(context.Context) error) error {
+	return s.retry.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+		return fn(ctx)
+	})
 }
 
-func (s server) getCategory(m *pb.CustomerMessage) (cat pb.MessageCategory, err error) {
-	r := &categorisingpb.GetCategoryRequest{Text: m.Body}
-
-	conn, err := s.getConn(categorisingService)
-	defer func() {
-		if err := conn.Close(); err != nil {
-			log.Printf("WARN: error closing connection: %v", err)
-		}
-	}()
-
-	c := categorisingpb.NewCategorisingClient(conn)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func (s server) getCategory(ctx context.Context, m *pb.CustomerMessage) (*categorisingpb.GetCategoryResponse, error) {
+	r := &categorisingpb.GetCategoryRequest{Text: m.Body, CustomerTier: m.CustomerTier}
 
-	resp, err := c.GetCategory(ctx, r)
-	if err == nil {
-		cat = resp.GetCategory()
-	}
+	var resp *categorisingpb.GetCategoryResponse
+	err := s.breaker.Do(ctx, func(ctx context.Context) error {
+		return s.call(ctx, func(ctx context.Context) (err error) {
+			resp, err = s.categorising.GetCategory(ctx, r)
+			return
+		})
+	})
 
-	return
+	return resp, err
 }
 
-func (s server) storeMessage(m *pb.CustomerMessage) (string, string, error) {
-	key := createKey(m)
-
-	data, _ := proto.Marshal(m)
-	r := &storagepb.CreateObjectRequest{Key: key, Data: data}
-
-	c, closeConn, err := s.getStorageClient()
-	if err != nil {
-		return "", "", err
+// Categorises several messages in one call. The responses are in the order of
+// the messages.
+func (s server) getCategories(ctx context.Context, msgs []*pb.CustomerMessage) ([]*categorisingpb.GetCategoryResponse, error) {
+	r := &categorisingpb.BatchGetCategoryRequest{}
+	for _, m := range msgs {
+		r.Requests = append(r.Requests, &categorisingpb.GetCategoryRequest{Text: m.Body, CustomerTier: m.CustomerTier})
 	}
-	defer closeConn()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	resp, err := c.CreateObject(ctx, r)
-	if err != nil {
-		log.Printf("WARN: storing message: %v", err)
-		return "", "", err
-	} else if resp.GetName() != "" {
-		log.Printf("DEBUG: stored message %v", m.Body)
-	} else {
-		log.Printf("WARN: message already stored %v", m.Body)
+	var resp *categorisingpb.BatchGetCategoryResponse
+	err := s.breaker.Do(ctx, func(ctx context.Context) error {
+		return s.call(ctx, func(ctx context.Context) (err error) {
+			resp, err = s.categorising.BatchGetCategory(ctx, r)
+			return
+		})
+	})
+	if err == nil && len(resp.GetResponses()) != len(msgs) {
+		err = status.Errorf(codes.Internal, "expected %v categories, got %v", len(msgs), len(resp.GetResponses()))
 	}
 
-	return resp.GetName(), resp.GetEtag(), err
+	return resp.GetResponses(), err
 }
 
-func (s server) getMessages(cat pb.MessageCategory, st pb.Status, l int32) (msgs []*pb.CustomerMessage, etags []string, err error) {
-	query := &storagepb.Key{
-		IndexedValues: []*storagepb.Key_Part{
-			{Key: "category", Value: cat.String()},
-			{Key: "status", Value: st.String()},
-		},
-	}
-
-	c, closeConn, err := s.getStorageClient()
-	if err != nil {
-		return
-	}
-	defer closeConn()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// Finds the products, order numbers and email addresses in the body
+func (s server) getEntities(ctx context.Context, m *pb.CustomerMessage) ([]*pb.Entity, error) {
+	r := &categorisingpb.ExtractEntitiesRequest{Text: m.Body}
 
-	r := &storagepb.GetObjectRequest{Keys: []*storagepb.Key{query}, Limit: l}
+	var resp *categorisingpb.ExtractEntitiesResponse
+	err := s.breaker.Do(ctx, func(ctx context.Context) error {
+		return s.call(ctx, func(ctx context.Context) (err error) {
+			resp, err = s.categorising.ExtractEntities(ctx, r)
+			return
+		})
+	})
 
-	resp, err := c.GetObject(ctx, r)
-	if err != nil {
-		log.Printf("WARN: error getting stored message: %v", err)
-		return
-	}
-
-	for i := 0; i < len(resp.GetEntries()); i += 1 {
-		e := resp.GetEntries()[i]
-		m := &pb.CustomerMessage{}
-		_ = proto.Unmarshal(e.GetData(), m)
-		msgs = append(msgs, m)
-		etags = append(etags, e.GetEtag())
-	}
-
-	return
+	return resp.GetEntities(), err
 }
 
-func (s server) mutateMessage(oldM, newM *pb.CustomerMessage, etag string) (string, error) {
-	oldKey := createKey(oldM)
-	newKey := createKey(newM)
-
-	newData, _ := proto.Marshal(newM)
-	r := &storagepb.MutateObjectRequest{OldKey: oldKey, NewKey: newKey, OldEtag: etag, NewData: newData}
-
-	c, closeConn, err := s.getStorageClient()
-	if err != nil {
-		return "", err
+// Records the references in the body, so the message can be found by them.
+// When categorising is down the message goes without; only running out of
+// time is an error.
+func (s server) extractEntities(ctx context.Context, m *pb.CustomerMessage) error {
+	es, err := s.getEntities(ctx, m)
+	if err != nil && ctx.Err() != nil {
+		return err
+	} else if err != nil {
+		log.Printf("WARN: could not extract entities for %s: %s", m.GetName(), err)
+		return nil
 	}
-	defer closeConn()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	m.Entities = es
+	return nil
+}
 
-	resp, err := c.MutateObject(ctx, r)
-	if err != nil {
-		log.Printf("WARN: could not mutated message \"%s\"", oldM.Body)
-		return "", err
-	} else if resp.GetNewEtag() == "" {
-		log.Printf("WARN: could not mutated message \"%s\"", oldM.Body)
+// Files the message under its category, or in triage when the categoriser
+// was not confident enough. Responses without candidates come from
+// categorisers that do not report confidence and are trusted. Garbage is
+// discarded straight away, keeping it out of the queues. The detected
+// language is kept so agents can be handed messages they can read, the
+// priority so urgent messages are handed out first.
+func fileUnder(m *pb.CustomerMessage, resp *categorisingpb.GetCategoryResponse, threshold float32) {
+	m.SuggestedCategory = resp.GetCategory()
+	m.CategoryConfidence = resp.GetConfidence()
+	m.LanguageCode = resp.GetLanguageCode()
+	m.Priority = resp.GetPriority()
+	if len(resp.GetCandidates()) > 0 && resp.GetConfidence() < threshold {
+		m.Category = pb.MessageCategory_TRIAGE
 	} else {
-		log.Printf("DEBUG: mutated message \"%s\"", oldM.Body)
+		m.Category = resp.GetCategory()
+	}
+	if m.Category == pb.MessageCategory_GARBAGE {
+		m.Status = pb.Status_DISCARDED
 	}
-
-	return resp.GetNewEtag(), err
 }
 
 func (s server) CreateMessage(ctx context.Context, r *pb.CreateMessageRequest) (*pb.CustomerMessage, error) {
 	m := r.GetCustomerMessage()
-	m.Status = pb.Status_TO_DO
 
-	// set category
-	var err error
-	var cat pb.MessageCategory
-	for i := -1; (err != nil || i < 0) && i < maxRetries; i += 1 {
-		cat, err = s.getCategory(m)
-	}
-	if err != nil {
-		log.Printf("WARN: could not get category: %s", err)
-		return nil, err
-	}
-	m.Category = cat
-
-	// store message
-	var name string
-	for i := -1; (err != nil || i < 0) && i < maxRetries; i += 1 {
-		name, _, err = s.storeMessage(m)
-	}
+	name, err := s.reserveName(ctx, r.GetRequestId())
 	if err != nil {
-		log.Printf("ERROR: error while storing message: %s", err)
 		return nil, err
-	} else if name == "" {
-		log.Printf("WARN: could not store message: %s", m.Body)
-		return nil, nil
-	}
-
-	m.Name = name
-	return m, nil
-}
-
-// Claims a message.
-func (s server) claimFirst(msgs []*pb.CustomerMessage, etags []string) (m *pb.CustomerMessage, newEtag string, err error) {
-	for i := 0; m == nil && i < len(msgs); i += 1 {
-		oldM := *msgs[i]
-		m = msgs[i]
-		m.Status = pb.Status_IN_PROCESS
-		newEtag, err := s.mutateMessage(&oldM, m, etags[i])
-		if newEtag == "" || err != nil {
-			m = nil
-		}
 	}
-	return
-}
-
-func (s server) GetQuestion(context.Context, *pb.GetQuestionRequest) (resp *pb.CustomerMessage, err error) {
-	for {
-		msgs, etags, err := s.getMessages(pb.MessageCategory_QUESTION, pb.Status_TO_DO, messageLimit)
-		if err != nil {
-			log.Printf("WARN: error retrieving messages: %s", err)
-			return nil, err
-		}
-		if len(msgs) == 0 {
-			break
-		}
-
-		m, _, err := s.claimFirst(msgs, etags)
-		if err != nil {
-			log.Printf("WARN: error getting question: %s", err)
+	if r.GetRequestId() != "" {
+		if existing, _, err := s.store.Get(ctx, name); err == nil {
+			log.Printf("INFO: repeated request %s for %s", r.GetRequestId(), name)
+			return existing, nil
+		} else if status.Code(err) != codes.NotFound {
 			return nil, err
-		} else {
-			return m, err
 		}
 	}
+	m.Name = name
+	m.Status = pb.Status_TO_DO
 
-	return nil, nil
-}
-
-func (s server) GetComplaint(context.Context, *pb.GetComplaintRequest) (*pb.CustomerMessage, error) {
-	for {
-		msgs, etags, err := s.getMessages(pb.MessageCategory_COMPLAINT, pb.Status_TO_DO, messageLimit)
-		if err != nil {
-			log.Printf("WARN: error retrieving messages: %s", err)
-			return nil, err
-		}
-		if len(msgs) == 0 {
-			break
-		}
-
-		m, _, err := s.claimFirst(msgs, etags)
-		if err != nil {
-			log.Printf("WARN: error getting question: %s", err)
-			return nil, err
-		} else {
-			return m, err
-		}
+	if s.ingest != nil {
+		return s.acceptMessage(ctx, m)
 	}
 
-	return nil, nil
-}
-
-func (s server) GetFeedback(context.Context, *pb.GetFeedbackRequest) (resp *pb.CustomerMessage, err error) {
-	for {
-		msgs, etags, err := s.getMessages(pb.MessageCategory_FEEDBACK, pb.Status_TO_DO, messageLimit)
-		if err != nil {
-			log.Printf("WARN: error retrieving messages: %s", err)
-			return nil, err
-		}
-		if len(msgs) == 0 {
-			break
-		}
-
-		m, _, err := s.claimFirst(msgs, etags)
-		if err != nil {
-			log.Printf("WARN: error getting question: %s", err)
-			return nil, err
-		} else {
-			return m, err
-		}
+	// the customer tier weighs in on the priority
+	if err := s.linkCustomer(ctx, m); err != nil {
+		return nil, err
 	}
 
-	return nil, nil
-}
-
-func (server) MoveMessage(context.Context, *pb.MoveMessageRequest) (*pb.CustomerMessage, error) {
-	panic("implement me")
-}
-
-func (server) UpdateStatus(context.Context, *pb.UpdateStatusRequest) (*pb.CustomerMessage, error) {
-	panic("implement me")
-}
-
-func (s server) GetMessage(_ context.Context, req *pb.GetMessageRequest) (*pb.CustomerMessage, error) {
-	c, closeConn, err := s.getStorageClient()
-	if err != nil {
+	// set category; when categorising is down, store the message anyway and
+	// leave it to the re-categorisation worker
+	resp, err := s.getCategory(ctx, m)
+	if err != nil && ctx.Err() != nil {
 		return nil, err
+	} else if err != nil {
+		log.Printf("WARN: could not get category, storing as %s: %s", pb.MessageCategory_NONE, err)
+		m.Category = pb.MessageCategory_NONE
+		m.NeedsCategorisation = true
+	} else {
+		fileUnder(m, resp, s.uncertainThreshold)
 	}
-	defer closeConn()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	key := req.GetName()
-	r := &storagepb.GetObjectRequest{Keys: []*storagepb.Key{{Name: key}}, Limit: 1}
-
-	objResp, err := c.GetObject(ctx, r)
-	if err != nil {
-		log.Printf("WARN: error fetching message '%s'", key)
+	if err := s.extractEntities(ctx, m); err != nil {
 		return nil, err
 	}
 
-	m := &pb.CustomerMessage{}
-	err = proto.Unmarshal(objResp.GetEntries()[0].GetData(), m)
+	// store message
+	stored, _, err := s.storeNew(ctx, m)
 	if err != nil {
-		log.Printf("WARN: error unmarshalling message '%s'", key)
-		return nil, err
+		log.Printf("ERROR: error while storing message: %s", err)
 	}
+	return stored, err
+}
 
-	return m, nil
+func (s server) GetTriageMessage(ctx context.Context, r *pb.GetTriageMessageRequest) (*pb.CustomerMessage, error) {
+	return s.queues[pb.MessageCategory_TRIAGE].Claim(ctx, r.GetAgent(), r.GetLanguageCodes()...)
 }
 
-func (server) SearchMessages(context.Context, *pb.SearchMessagesRequest) (*pb.SearchMessagesResponse, error) {
-	panic("implement me")
+func (s server) GetMessage(ctx context.Context, req *pb.GetMessageRequest) (*pb.CustomerMessage, error) {
+	m, _, err := s.store.Get(ctx, req.GetName())
+	return m, err
 }
 
 func (s server) DeleteMessage(ctx context.Context, req *pb.DeleteMessageRequest) (*empty.Empty, error) {
-	r := &storagepb.DeleteObjectRequest{Keys: []*storagepb.Key{{Name: req.Name}}}
-
-	c, closeConn, err := s.getStorageClient()
+	replies, err := s.getReplies(ctx, req.GetName())
 	if err != nil {
 		return nil, err
 	}
-	defer closeConn()
 
-	_, err = c.DeleteObject(ctx, r)
+	keys := []*storagepb.Key{workqueue.MessageKey(req.GetName())}
+	for _, reply := range replies {
+		keys = append(keys, replyKey(req.GetName(), reply.GetName()))
+	}
+	r := &storagepb.DeleteObjectRequest{Keys: keys}
+
+	err = s.store.Call(ctx, func(ctx context.Context) (err error) {
+		_, err = s.store.Client().DeleteObject(ctx, r)
+		return
+	})
 	if err != nil {
 		log.Printf("WARN: error getting stored message: %v", err)
 		return nil, err
@@ -389,6 +308,16 @@ func main() {
 	var storagePort = flag.String("storage-port", defaultPort, "storage service port")
 	var categorisingHost = flag.String("categorising-host", categorisingService, "categorising service")
 	var categorisingPort = flag.String("categorising-port", defaultPort, "categorising service port")
+	var customersHost = flag.String("customers-host", customersService, "customers service")
+	var customersPort = flag.String("customers-port", defaultPort, "customers service port")
+	var leaseDuration = flag.Duration("lease-duration", workqueue.DefaultLeaseDuration, "time an agent may hold a claimed message")
+	var leaseCheckInterval = flag.Duration("lease-check-interval", workqueue.DefaultLeaseCheckInterval, "interval for returning expired claims to the queue")
+	var recategoriseInterval = flag.Duration("recategorise-interval", defaultRecategoriseInterval, "interval for categorising messages stored while categorising was down")
+	var asyncIngestion = flag.Bool("async-ingestion", false, "store new messages first and process them in the background")
+	var ingestionWorkers = flag.Int("ingestion-workers", defaultIngestionWorkers, "number of concurrent ingestion workers")
+	var ingestionInterval = flag.Duration("ingestion-interval", defaultIngestionInterval, "interval for picking up unfinished ingestions")
+	var uncertainThreshold = flag.Float64("uncertain-threshold", 0, "minimum categorisation confidence for filing a message without triage (0 disables triage)")
+	var dialMeasuring = measure.RegisterFlags(defaultPort)
 	flag.Parse()
 
 	lis, err := net.Listen("tcp", ":"+*port)
@@ -396,15 +325,55 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
-	pb.RegisterMessagingServer(s, newServer(map[string]string{
+	recorder, err := dialMeasuring()
+	if err != nil {
+		log.Fatalf("failed to connect to measuring: %v", err)
+	}
+
+	manager := clients.NewManager(map[string]string{
 		storageService:      *storageHost + ":" + *storagePort,
 		categorisingService: *categorisingHost + ":" + *categorisingPort,
-	}))
+		customersService:    *customersHost + ":" + *customersPort,
+	})
+
+	srv, err := newServerFromManager(manager, *leaseDuration)
+	if err != nil {
+		log.Fatalf("failed to create server: %v", err)
+	}
+	srv.uncertainThreshold = float32(*uncertainThreshold)
+	var queues []*workqueue.Queue
+	for _, q := range srv.queues {
+		queues = append(queues, q)
+	}
+	go workqueue.Reap(*leaseCheckInterval, queues...)
+	go srv.recategoriseLoop(*recategoriseInterval)
+	if *asyncIngestion {
+		srv.ingest = make(chan string, ingestionQueueSize)
+		srv.runIngestion(*ingestionWorkers, *ingestionInterval)
+	}
+
+	s := grpc.NewServer(recorder.ServerOptions()...)
+	pb.RegisterMessagingServer(s, srv)
+	healthpb.RegisterHealthServer(s, health.NewServer())
 
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Print("INFO: shutting down")
+		s.GracefulStop()
+	}()
+
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+	if err := manager.Close(); err != nil {
+		log.Printf("WARN: error closing connections: %v", err)
+	}
+	if err := recorder.Close(); err != nil {
+		log.Printf("WARN: error closing measuring: %v", err)
+	}
 }
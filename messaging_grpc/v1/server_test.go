@@ -0,0 +1,275 @@
+package main
+
+import (
+	categorisingpb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/categorising/v1"
+	customerspb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/customers/v1"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/retry"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// A categorising server that files everything under one category
+type fakeCategorising struct {
+	category pb.MessageCategory
+	// reported confidence; none is reported when zero
+	confidence float32
+	language   string
+	entities   []*pb.Entity
+	priority   int32
+	errs       []error
+	calls      int
+	batches    int
+	// the customer tier of the last request
+	tier customerspb.Customer_Tier
+}
+
+// Registers the call and pops the next queued error
+func (f *fakeCategorising) call() error {
+	f.calls += 1
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	return nil
+}
+
+func (f *fakeCategorising) GetCategory(ctx context.Context, r *categorisingpb.GetCategoryRequest, _ ...grpc.CallOption) (*categorisingpb.GetCategoryResponse, error) {
+	if err := f.call(); err != nil {
+		return nil, err
+	}
+	f.tier = r.GetCustomerTier()
+	resp := &categorisingpb.GetCategoryResponse{Category: f.category, LanguageCode: f.language, Priority: f.priority}
+	if f.confidence > 0 {
+		resp.Confidence = f.confidence
+		resp.Candidates = []*categorisingpb.CategoryCandidate{{Category: f.category, Confidence: f.confidence}}
+	}
+	return resp, nil
+}
+
+func (f *fakeCategorising) BatchGetCategory(ctx context.Context, r *categorisingpb.BatchGetCategoryRequest, _ ...grpc.CallOption) (*categorisingpb.BatchGetCategoryResponse, error) {
+	f.batches += 1
+	resp := &categorisingpb.BatchGetCategoryResponse{}
+	for _, req := range r.GetRequests() {
+		res, err := f.GetCategory(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Responses = append(resp.Responses, res)
+	}
+	return resp, nil
+}
+
+func (f *fakeCategorising) StreamGetCategory(context.Context, ...grpc.CallOption) (categorisingpb.Categorising_StreamGetCategoryClient, error) {
+	return nil, status.Error(codes.Unimplemented, "not supported by fake")
+}
+
+func (f *fakeCategorising) ExtractEntities(context.Context, *categorisingpb.ExtractEntitiesRequest, ...grpc.CallOption) (*categorisingpb.ExtractEntitiesResponse, error) {
+	if err := f.call(); err != nil {
+		return nil, err
+	}
+	return &categorisingpb.ExtractEntitiesResponse{Entities: f.entities}, nil
+}
+
+func (f *fakeCategorising) GetStats(context.Context, *categorisingpb.GetStatsRequest, ...grpc.CallOption) (*categorisingpb.GetStatsResponse, error) {
+	return &categorisingpb.GetStatsResponse{}, nil
+}
+
+var testPolicy = retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Multiplier: 2}
+
+func newTestServer(storage storagepb.StorageClient, categorising *fakeCategorising) *server {
+	return newServer(storage, categorising, time.Minute, testPolicy)
+}
+
+func newTestMessage() *pb.CustomerMessage {
+	return &pb.CustomerMessage{
+		Sender:    &pb.Sender{Name: "alice"},
+		Body:      "Do you sell knobs?",
+		Timestamp: 1000,
+	}
+}
+
+func newCreateRequest() *pb.CreateMessageRequest {
+	return &pb.CreateMessageRequest{Message: &pb.CreateMessageRequest_CustomerMessage{CustomerMessage: newTestMessage()}}
+}
+
+func TestServer_CreateMessage(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "")
+	deadline := status.Error(codes.DeadlineExceeded, "")
+	invalid := status.Error(codes.InvalidArgument, "")
+
+	cases := []struct {
+		categorisingErrs  []error
+		storageErrs       []error
+		categorisingCalls int
+		storageCalls      int
+		expected          codes.Code
+		category          pb.MessageCategory
+	}{
+		// categorising calls include the entity extraction
+		{nil, nil, 2, 1, codes.OK, pb.MessageCategory_QUESTION},
+		{[]error{unavailable}, []error{deadline}, 3, 2, codes.OK, pb.MessageCategory_QUESTION},
+		{[]error{unavailable, unavailable, unavailable}, nil, 4, 1, codes.OK, pb.MessageCategory_NONE},
+		{[]error{invalid}, nil, 2, 1, codes.OK, pb.MessageCategory_NONE},
+		{nil, []error{unavailable, unavailable, unavailable}, 2, 3, codes.Unavailable, pb.MessageCategory_NONE},
+		{nil, []error{unavailable, invalid}, 2, 2, codes.InvalidArgument, pb.MessageCategory_NONE},
+	}
+	for i, c := range cases {
+		storage := storagetest.NewFake(c.storageErrs...)
+		categorising := &fakeCategorising{category: pb.MessageCategory_QUESTION, errs: c.categorisingErrs}
+		s := newTestServer(storage, categorising)
+
+		m, err := s.CreateMessage(context.Background(), newCreateRequest())
+		if status.Code(err) != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, err)
+		}
+		if categorising.calls != c.categorisingCalls {
+			t.Errorf("case %v: expected %v categorising calls, got %v", i, c.categorisingCalls, categorising.calls)
+		}
+		if storage.Calls != c.storageCalls {
+			t.Errorf("case %v: expected %v storage calls, got %v", i, c.storageCalls, storage.Calls)
+		}
+		if err == nil && (m.GetName() == "" || m.GetCategory() != c.category) {
+			t.Errorf("case %v: expected stored %s, got %v", i, c.category, m)
+		}
+	}
+}
+
+func TestFileUnder(t *testing.T) {
+	candidates := []*categorisingpb.CategoryCandidate{{Category: pb.MessageCategory_COMPLAINT, Confidence: .4}}
+	cases := []struct {
+		resp      *categorisingpb.GetCategoryResponse
+		threshold float32
+		expected  pb.MessageCategory
+	}{
+		{&categorisingpb.GetCategoryResponse{Category: pb.MessageCategory_COMPLAINT, Confidence: .4, Candidates: candidates}, 0, pb.MessageCategory_COMPLAINT},
+		{&categorisingpb.GetCategoryResponse{Category: pb.MessageCategory_COMPLAINT, Confidence: .4, Candidates: candidates}, .4, pb.MessageCategory_COMPLAINT},
+		{&categorisingpb.GetCategoryResponse{Category: pb.MessageCategory_COMPLAINT, Confidence: .4, Candidates: candidates}, .5, pb.MessageCategory_TRIAGE},
+		{&categorisingpb.GetCategoryResponse{Category: pb.MessageCategory_COMPLAINT}, .5, pb.MessageCategory_COMPLAINT},
+		{&categorisingpb.GetCategoryResponse{Category: pb.MessageCategory_GARBAGE}, .5, pb.MessageCategory_GARBAGE},
+		{&categorisingpb.GetCategoryResponse{Category: pb.MessageCategory_FEEDBACK, LanguageCode: "fr"}, .5, pb.MessageCategory_FEEDBACK},
+	}
+	for i, c := range cases {
+		m := &pb.CustomerMessage{Status: pb.Status_TO_DO}
+		fileUnder(m, c.resp, c.threshold)
+		if m.GetCategory() != c.expected {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, m.GetCategory())
+		}
+		if discarded := m.GetStatus() == pb.Status_DISCARDED; discarded != (c.expected == pb.MessageCategory_GARBAGE) {
+			t.Errorf("case %v: expected only garbage to be discarded, got %v", i, m.GetStatus())
+		}
+		if m.GetSuggestedCategory() != c.resp.GetCategory() || m.GetCategoryConfidence() != c.resp.GetConfidence() {
+			t.Errorf("case %v: expected suggestion to be recorded, got %v", i, m)
+		}
+		if m.GetLanguageCode() != c.resp.GetLanguageCode() {
+			t.Errorf("case %v: expected language %q, got %q", i, c.resp.GetLanguageCode(), m.GetLanguageCode())
+		}
+	}
+}
+
+func TestServer_GetTriageMessage(t *testing.T) {
+	s := newTestServer(storagetest.NewFake(), &fakeCategorising{category: pb.MessageCategory_QUESTION, confidence: .3, language: "fr"})
+	s.uncertainThreshold = .5
+
+	m, err := s.CreateMessage(context.Background(), newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.GetCategory() != pb.MessageCategory_TRIAGE || m.GetSuggestedCategory() != pb.MessageCategory_QUESTION {
+		t.Errorf("expected message in triage, got %v", m)
+	}
+	if _, err := s.queues[pb.MessageCategory_QUESTION].Claim(context.Background(), "bob"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected no questions, got %v", err)
+	}
+
+	if _, err := s.GetTriageMessage(context.Background(), &pb.GetTriageMessageRequest{Agent: "bob", LanguageCodes: []string{"nl"}}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected no Dutch messages, got %v", err)
+	}
+	claimed, err := s.GetTriageMessage(context.Background(), &pb.GetTriageMessageRequest{Agent: "bob", LanguageCodes: []string{"fr", "nl"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claimed.GetName() != m.GetName() || claimed.GetLease().GetHolder() != "bob" {
+		t.Errorf("expected %s claimed by bob, got %v", m.GetName(), claimed)
+	}
+
+	moved, err := s.MoveMessage(context.Background(), &pb.MoveMessageRequest{
+		MessageId:   m.GetName(),
+		OldCategory: pb.MessageCategory_TRIAGE,
+		NewCategory: pb.MessageCategory_COMPLAINT,
+		Agent:       "bob",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if moved.GetCategory() != pb.MessageCategory_COMPLAINT || moved.GetStatus() != pb.Status_TO_DO {
+		t.Errorf("expected message filed as complaint, got %v", moved)
+	}
+	if _, err := s.GetTriageMessage(context.Background(), &pb.GetTriageMessageRequest{Agent: "bob"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected triage to be empty, got %v", err)
+	}
+}
+
+func TestServer_CreateMessageContext(t *testing.T) {
+	storage := storagetest.NewFake()
+	s := newTestServer(storage, &fakeCategorising{category: pb.MessageCategory_QUESTION})
+
+	deadline := time.Now().Add(time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if _, err := s.CreateMessage(ctx, newCreateRequest()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if storage.Deadline.After(deadline) {
+		t.Errorf("expected caller deadline %v to be propagated, got %v", deadline, storage.Deadline)
+	}
+
+	storage = storagetest.NewFake(status.Error(codes.Unavailable, ""))
+	slow := testPolicy
+	slow.InitialBackoff = time.Hour
+	slow.MaxBackoff = time.Hour
+	s = newServer(storage, &fakeCategorising{category: pb.MessageCategory_QUESTION}, time.Minute, slow)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := s.CreateMessage(ctx, newCreateRequest())
+	if status.Code(err) != codes.Canceled {
+		t.Errorf("expected %v, got %v", codes.Canceled, err)
+	}
+	if storage.Calls != 1 {
+		t.Errorf("expected no retries after cancellation, got %v calls", storage.Calls)
+	}
+}
+
+func TestServer_GetMessage(t *testing.T) {
+	storage := storagetest.NewFake()
+	s := newTestServer(storage, &fakeCategorising{category: pb.MessageCategory_QUESTION})
+
+	m, err := s.CreateMessage(context.Background(), newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	storage.Errs = []error{status.Error(codes.Unavailable, "")}
+	actual, err := s.GetMessage(context.Background(), &pb.GetMessageRequest{Name: m.GetName()})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if actual.GetName() != m.GetName() || actual.GetBody() != m.GetBody() {
+		t.Errorf("expected %v, got %v", m, actual)
+	}
+
+	_, err = s.GetMessage(context.Background(), &pb.GetMessageRequest{Name: "nope"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v, got %v", codes.NotFound, err)
+	}
+}
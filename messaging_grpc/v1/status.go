@@ -0,0 +1,97 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"time"
+)
+
+// The message workflow: allowed status transitions per status.
+// Any message may be discarded, a discarded message can only be restored to
+// TO_DO.
+var transitions = map[pb.Status][]pb.Status{
+	pb.Status_TO_DO:      {pb.Status_IN_PROCESS, pb.Status_DISCARDED},
+	pb.Status_IN_PROCESS: {pb.Status_TO_DO, pb.Status_ON_HOLD, pb.Status_DONE, pb.Status_DISCARDED},
+	pb.Status_ON_HOLD:    {pb.Status_TO_DO, pb.Status_IN_PROCESS, pb.Status_DONE, pb.Status_DISCARDED},
+	pb.Status_DONE:       {pb.Status_DISCARDED},
+	pb.Status_DISCARDED:  {pb.Status_TO_DO},
+}
+
+// Reports whether the workflow allows moving from one status to the other
+func canTransition(from, to pb.Status) bool {
+	for _, st := range transitions[from] {
+		if st == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates a copy of the message with the new status. Moving into IN_PROCESS
+// claims the message for the agent, any other status drops the claim.
+func withStatus(m *pb.CustomerMessage, st pb.Status, agent string, d time.Duration) *pb.CustomerMessage {
+	if st == pb.Status_IN_PROCESS {
+		return workqueue.WithLease(m, agent, d)
+	}
+	newM := proto.Clone(m).(*pb.CustomerMessage)
+	newM.Status = st
+	newM.Lease = nil
+	return newM
+}
+
+// Reports whether the message is claimed by someone other than the agent
+func claimedByOther(m *pb.CustomerMessage, agent string, now int64) bool {
+	return m.GetStatus() == pb.Status_IN_PROCESS &&
+		!workqueue.Reclaimable(m, now) &&
+		m.GetLease().GetHolder() != agent
+}
+
+func (s server) UpdateStatus(ctx context.Context, r *pb.UpdateStatusRequest) (*pb.CustomerMessage, error) {
+	if err := workqueue.ValidateAgent(r.GetAgent()); err != nil {
+		return nil, err
+	}
+	m, etag, err := s.store.Get(ctx, r.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	if !canTransition(m.GetStatus(), r.GetStatus()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "cannot move %s from %s to %s", r.GetName(), m.GetStatus(), r.GetStatus())
+	}
+	if claimedByOther(m, r.GetAgent(), workqueue.NowMillis()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is claimed by '%s'", r.GetName(), m.GetLease().GetHolder())
+	}
+
+	newM := withStatus(m, r.GetStatus(), r.GetAgent(), s.leaseDuration)
+	newEtag, err := s.store.Mutate(ctx, m, newM, etag)
+	if err != nil {
+		return nil, err
+	} else if newEtag == "" {
+		return nil, status.Errorf(codes.Aborted, "concurrent modification of %s", r.GetName())
+	}
+
+	log.Printf("DEBUG: %s moved from %s to %s", r.GetName(), m.GetStatus(), r.GetStatus())
+	return newM, nil
+}
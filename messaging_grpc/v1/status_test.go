@@ -0,0 +1,132 @@
+package main
+
+import (
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to pb.Status
+		allowed  bool
+	}{
+		{pb.Status_TO_DO, pb.Status_TO_DO, false},
+		{pb.Status_TO_DO, pb.Status_IN_PROCESS, true},
+		{pb.Status_TO_DO, pb.Status_ON_HOLD, false},
+		{pb.Status_TO_DO, pb.Status_DONE, false},
+		{pb.Status_TO_DO, pb.Status_DISCARDED, true},
+
+		{pb.Status_IN_PROCESS, pb.Status_TO_DO, true},
+		{pb.Status_IN_PROCESS, pb.Status_IN_PROCESS, false},
+		{pb.Status_IN_PROCESS, pb.Status_ON_HOLD, true},
+		{pb.Status_IN_PROCESS, pb.Status_DONE, true},
+		{pb.Status_IN_PROCESS, pb.Status_DISCARDED, true},
+
+		{pb.Status_ON_HOLD, pb.Status_TO_DO, true},
+		{pb.Status_ON_HOLD, pb.Status_IN_PROCESS, true},
+		{pb.Status_ON_HOLD, pb.Status_ON_HOLD, false},
+		{pb.Status_ON_HOLD, pb.Status_DONE, true},
+		{pb.Status_ON_HOLD, pb.Status_DISCARDED, true},
+
+		{pb.Status_DONE, pb.Status_TO_DO, false},
+		{pb.Status_DONE, pb.Status_IN_PROCESS, false},
+		{pb.Status_DONE, pb.Status_ON_HOLD, false},
+		{pb.Status_DONE, pb.Status_DONE, false},
+		{pb.Status_DONE, pb.Status_DISCARDED, true},
+
+		{pb.Status_DISCARDED, pb.Status_TO_DO, true},
+		{pb.Status_DISCARDED, pb.Status_IN_PROCESS, false},
+		{pb.Status_DISCARDED, pb.Status_ON_HOLD, false},
+		{pb.Status_DISCARDED, pb.Status_DONE, false},
+		{pb.Status_DISCARDED, pb.Status_DISCARDED, false},
+	}
+	for _, c := range cases {
+		if actual := canTransition(c.from, c.to); actual != c.allowed {
+			t.Errorf("%s -> %s: expected %v, got %v", c.from, c.to, c.allowed, actual)
+		}
+	}
+}
+
+func TestWithStatus(t *testing.T) {
+	leased := workqueue.WithLease(&pb.CustomerMessage{Name: "foo"}, "alice", time.Minute)
+
+	cases := []struct {
+		m      *pb.CustomerMessage
+		status pb.Status
+		holder string
+	}{
+		{&pb.CustomerMessage{Name: "foo"}, pb.Status_IN_PROCESS, "bob"},
+		{&pb.CustomerMessage{Name: "foo", Status: pb.Status_ON_HOLD}, pb.Status_IN_PROCESS, "bob"},
+		{leased, pb.Status_DONE, ""},
+		{leased, pb.Status_ON_HOLD, ""},
+		{leased, pb.Status_TO_DO, ""},
+	}
+	for i, c := range cases {
+		actual := withStatus(c.m, c.status, "bob", time.Minute)
+		if actual.GetStatus() != c.status {
+			t.Errorf("case %v: expected status %s, got %s", i, c.status, actual.GetStatus())
+		}
+		if actual.GetLease().GetHolder() != c.holder {
+			t.Errorf("case %v: expected lease holder '%s', got '%s'", i, c.holder, actual.GetLease().GetHolder())
+		}
+	}
+}
+
+func TestClaimedByOther(t *testing.T) {
+	now := workqueue.NowMillis()
+	cases := []struct {
+		m       *pb.CustomerMessage
+		claimed bool
+	}{
+		{&pb.CustomerMessage{Status: pb.Status_TO_DO}, false},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS}, false},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS, Lease: &pb.Lease{Holder: "alice", ExpireTime: now + 1000}}, false},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS, Lease: &pb.Lease{Holder: "bob", ExpireTime: now + 1000}}, true},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS, Lease: &pb.Lease{Holder: "bob", ExpireTime: now}}, false},
+		{&pb.CustomerMessage{Status: pb.Status_IN_PROCESS, Lease: &pb.Lease{ExpireTime: now + 1000}}, false},
+	}
+	for i, c := range cases {
+		if actual := claimedByOther(c.m, "alice", now); actual != c.claimed {
+			t.Errorf("case %v: expected %v, got %v", i, c.claimed, actual)
+		}
+	}
+}
+
+func TestServer_MissingAgent(t *testing.T) {
+	s := newTestServer(storagetest.NewFake(), &fakeCategorising{})
+	ctx := context.Background()
+	m, err := s.CreateMessage(ctx, newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	calls := []func() error{
+		func() error {
+			_, err := s.UpdateStatus(ctx, &pb.UpdateStatusRequest{Name: m.GetName(), Status: pb.Status_IN_PROCESS})
+			return err
+		},
+		func() error {
+			_, err := s.MoveMessage(ctx, &pb.MoveMessageRequest{MessageId: m.GetName(), OldCategory: m.GetCategory(), NewCategory: pb.MessageCategory_FEEDBACK})
+			return err
+		},
+		func() error {
+			_, err := s.ResolveThread(ctx, &pb.ResolveThreadRequest{Name: m.GetName()})
+			return err
+		},
+		func() error {
+			_, err := s.GetTriageMessage(ctx, &pb.GetTriageMessageRequest{})
+			return err
+		},
+	}
+	for i, call := range calls {
+		if err := call(); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("case %v: expected %v, got %v", i, codes.InvalidArgument, err)
+		}
+	}
+}
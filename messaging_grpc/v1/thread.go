@@ -0,0 +1,220 @@
+/*
+ * Copyright 2019 Hayo van Loon
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/ulid"
+	"github.com/HayoVanLoon/protoworkflow/commons/workqueue"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"sort"
+)
+
+// Creates a key for a reply; replies are stored under their parent message
+func replyKey(parent, name string) *storagepb.Key {
+	return &storagepb.Key{Parts: []*storagepb.Key_Part{
+		{Key: "parent", Value: parent},
+		{Key: "reply", Value: name},
+	}}
+}
+
+// Creates a query for all replies to a message
+func threadQuery(parent string) *storagepb.Key {
+	return &storagepb.Key{Parts: []*storagepb.Key_Part{{Key: "parent", Value: parent}}}
+}
+
+// Checks the parts of a reply that are set by its author
+func validateReply(r *pb.Reply) error {
+	if r.GetAuthorType() == pb.Reply_AUTHOR_TYPE_UNSPECIFIED {
+		return status.Error(codes.InvalidArgument, "missing author type")
+	} else if r.GetAuthor() == "" {
+		return status.Error(codes.InvalidArgument, "missing author")
+	} else if r.GetBody() == "" {
+		return status.Error(codes.InvalidArgument, "empty reply")
+	}
+	return nil
+}
+
+// Creates a copy of the message with the thread opened or closed
+func withResolved(m *pb.CustomerMessage, resolved bool) *pb.CustomerMessage {
+	newM := proto.Clone(m).(*pb.CustomerMessage)
+	newM.Resolved = resolved
+	return newM
+}
+
+// Stores a changed thread state on the parent message
+func (s server) setResolved(ctx context.Context, m *pb.CustomerMessage, etag string, resolved bool) (*pb.CustomerMessage, error) {
+	newM := withResolved(m, resolved)
+	newEtag, err := s.store.Mutate(ctx, m, newM, etag)
+	if err != nil {
+		return nil, err
+	} else if newEtag == "" {
+		return nil, status.Errorf(codes.Aborted, "concurrent modification of %s", m.GetName())
+	}
+	return newM, nil
+}
+
+func (s server) CreateReply(ctx context.Context, r *pb.CreateReplyRequest) (*pb.Reply, error) {
+	if err := validateReply(r.GetReply()); err != nil {
+		return nil, err
+	}
+
+	m, etag, err := s.store.Get(ctx, r.GetParent())
+	if err != nil {
+		return nil, err
+	}
+	if m.GetResolved() && r.GetReply().GetAuthorType() == pb.Reply_AGENT {
+		return nil, status.Errorf(codes.FailedPrecondition, "thread of %s is resolved", r.GetParent())
+	}
+
+	reply := proto.Clone(r.GetReply()).(*pb.Reply)
+	reply.Name = ulid.New()
+	reply.Parent = r.GetParent()
+	reply.Timestamp = workqueue.NowMillis()
+
+	data, _ := proto.Marshal(reply)
+	cr := &storagepb.CreateObjectRequest{Key: replyKey(reply.Parent, reply.Name), Data: data}
+	err = s.store.Call(ctx, func(ctx context.Context) (err error) {
+		_, err = s.store.Client().CreateObject(ctx, cr)
+		return
+	})
+	if err != nil {
+		log.Printf("WARN: storing reply to %s: %v", r.GetParent(), err)
+		return nil, err
+	}
+
+	// only reopen once the reply is stored, so a failed reply leaves the
+	// thread as it was
+	if m.GetResolved() {
+		if _, err := s.setResolved(ctx, m, etag, false); err != nil {
+			log.Printf("WARN: stored reply %s, but could not reopen thread of %s: %v", reply.Name, r.GetParent(), err)
+			return nil, err
+		}
+		log.Printf("INFO: thread of %s reopened by '%s'", r.GetParent(), r.GetReply().GetAuthor())
+	}
+
+	return reply, nil
+}
+
+// Retrieves all replies to a message, oldest first
+func (s server) getReplies(ctx context.Context, parent string) ([]*pb.Reply, error) {
+	gr := &storagepb.GetObjectRequest{Keys: []*storagepb.Key{threadQuery(parent)}}
+	var resp *storagepb.GetObjectResponse
+	err := s.store.Call(ctx, func(ctx context.Context) (err error) {
+		resp, err = s.store.Client().GetObject(ctx, gr)
+		return
+	})
+	if err != nil {
+		log.Printf("WARN: error getting replies to %s: %v", parent, err)
+		return nil, err
+	}
+
+	var replies []*pb.Reply
+	for _, e := range resp.GetEntries() {
+		reply := &pb.Reply{}
+		if err := proto.Unmarshal(e.GetData(), reply); err != nil {
+			log.Printf("WARN: error unmarshalling reply to %s", parent)
+			continue
+		}
+		replies = append(replies, reply)
+	}
+	sort.Slice(replies, func(i, j int) bool {
+		if replies[i].GetTimestamp() != replies[j].GetTimestamp() {
+			return replies[i].GetTimestamp() < replies[j].GetTimestamp()
+		}
+		return replies[i].GetName() < replies[j].GetName()
+	})
+	return replies, nil
+}
+
+// Cuts the requested page from replies sorted oldest first. Page tokens hold
+// the name of the last reply on the previous page.
+func pageReplies(replies []*pb.Reply, size int32, token string) ([]*pb.Reply, string, error) {
+	if token != "" {
+		bs, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return nil, "", status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		last := string(bs)
+		i := 0
+		for i < len(replies) && replies[i].GetName() != last {
+			i += 1
+		}
+		if i == len(replies) {
+			return nil, "", status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		replies = replies[i+1:]
+	}
+
+	n := int(size)
+	if n <= 0 {
+		n = defaultPageSize
+	} else if n > maxPageSize {
+		n = maxPageSize
+	}
+	if len(replies) <= n {
+		return replies, "", nil
+	}
+	next := base64.RawURLEncoding.EncodeToString([]byte(replies[n-1].GetName()))
+	return replies[:n], next, nil
+}
+
+func (s server) ListReplies(ctx context.Context, r *pb.ListRepliesRequest) (*pb.ListRepliesResponse, error) {
+	if _, _, err := s.store.Get(ctx, r.GetParent()); err != nil {
+		return nil, err
+	}
+
+	replies, err := s.getReplies(ctx, r.GetParent())
+	if err != nil {
+		return nil, err
+	}
+	page, next, err := pageReplies(replies, r.GetPageSize(), r.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListRepliesResponse{Replies: page, NextPageToken: next}, nil
+}
+
+func (s server) ResolveThread(ctx context.Context, r *pb.ResolveThreadRequest) (*pb.CustomerMessage, error) {
+	if err := workqueue.ValidateAgent(r.GetAgent()); err != nil {
+		return nil, err
+	}
+	m, etag, err := s.store.Get(ctx, r.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if m.GetResolved() {
+		return m, nil
+	}
+	if claimedByOther(m, r.GetAgent(), workqueue.NowMillis()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is claimed by '%s'", r.GetName(), m.GetLease().GetHolder())
+	}
+
+	newM, err := s.setResolved(ctx, m, etag, true)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("INFO: thread of %s resolved by '%s'", r.GetName(), r.GetAgent())
+	return newM, nil
+}
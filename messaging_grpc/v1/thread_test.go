@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/messaging/v1"
+	storagepb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/storagetest"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestValidateReply(t *testing.T) {
+	cases := []struct {
+		r     *pb.Reply
+		valid bool
+	}{
+		{&pb.Reply{AuthorType: pb.Reply_AGENT, Author: "bob", Body: "Yes we do."}, true},
+		{&pb.Reply{AuthorType: pb.Reply_CUSTOMER, Author: "alice", Body: "Thanks!"}, true},
+		{&pb.Reply{Author: "bob", Body: "Yes we do."}, false},
+		{&pb.Reply{AuthorType: pb.Reply_AGENT, Body: "Yes we do."}, false},
+		{&pb.Reply{AuthorType: pb.Reply_AGENT, Author: "bob"}, false},
+		{nil, false},
+	}
+	for i, c := range cases {
+		if err := validateReply(c.r); (err == nil) != c.valid {
+			t.Errorf("case %v: expected valid %v, got %v", i, c.valid, err)
+		}
+	}
+}
+
+func TestPageReplies(t *testing.T) {
+	var replies []*pb.Reply
+	for i := 0; i < 5; i += 1 {
+		replies = append(replies, &pb.Reply{Name: fmt.Sprintf("r%d", i)})
+	}
+
+	var names []string
+	token := ""
+	for {
+		page, next, err := pageReplies(replies, 2, token)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, r := range page {
+			names = append(names, r.GetName())
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if fmt.Sprint(names) != "[r0 r1 r2 r3 r4]" {
+		t.Errorf("expected all replies in order, got %v", names)
+	}
+
+	if _, _, err := pageReplies(replies, 2, "garbage"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %v, got %v", codes.InvalidArgument, err)
+	}
+}
+
+// Fails storing replies with err, when set
+type failingReplies struct {
+	*storagetest.Fake
+	err error
+}
+
+func (f *failingReplies) CreateObject(ctx context.Context, r *storagepb.CreateObjectRequest, opts ...grpc.CallOption) (*storagepb.CreateObjectResponse, error) {
+	for _, p := range r.GetKey().GetParts() {
+		if f.err != nil && p.GetKey() == "reply" {
+			return nil, f.err
+		}
+	}
+	return f.Fake.CreateObject(ctx, r, opts...)
+}
+
+func TestServer_Thread(t *testing.T) {
+	storage := &failingReplies{Fake: storagetest.NewFake()}
+	s := newTestServer(storage, &fakeCategorising{category: pb.MessageCategory_QUESTION})
+	ctx := context.Background()
+
+	m, err := s.CreateMessage(ctx, newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	other, err := s.CreateMessage(ctx, newCreateRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reply := func(parent string, at pb.Reply_AuthorType, author, body string) error {
+		_, err := s.CreateReply(ctx, &pb.CreateReplyRequest{
+			Parent: parent,
+			Reply:  &pb.Reply{AuthorType: at, Author: author, Body: body},
+		})
+		return err
+	}
+
+	if err := reply(m.GetName(), pb.Reply_AGENT, "bob", "Yes we do."); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := reply(other.GetName(), pb.Reply_AGENT, "bob", "No."); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := reply("nope", pb.Reply_AGENT, "bob", "Hello?"); status.Code(err) != codes.NotFound {
+		t.Errorf("expected %v, got %v", codes.NotFound, err)
+	}
+
+	resolved, err := s.ResolveThread(ctx, &pb.ResolveThreadRequest{Name: m.GetName(), Agent: "bob"})
+	if err != nil || !resolved.GetResolved() {
+		t.Fatalf("expected resolved thread, got %v, %v", resolved, err)
+	}
+	if err := reply(m.GetName(), pb.Reply_AGENT, "bob", "Anything else?"); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected %v, got %v", codes.FailedPrecondition, err)
+	}
+
+	// the thread stays resolved when the reply cannot be stored
+	storage.err = status.Error(codes.Internal, "")
+	if err := reply(m.GetName(), pb.Reply_CUSTOMER, "alice", "Hello?"); status.Code(err) != codes.Internal {
+		t.Errorf("expected %v, got %v", codes.Internal, err)
+	}
+	storage.err = nil
+	if actual, _ := s.GetMessage(ctx, &pb.GetMessageRequest{Name: m.GetName()}); !actual.GetResolved() {
+		t.Errorf("expected failed reply to leave the thread resolved")
+	}
+
+	if err := reply(m.GetName(), pb.Reply_CUSTOMER, "alice", "In red?"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual, _ := s.GetMessage(ctx, &pb.GetMessageRequest{Name: m.GetName()}); actual.GetResolved() {
+		t.Errorf("expected customer reply to reopen the thread")
+	}
+
+	resp, err := s.ListReplies(ctx, &pb.ListRepliesRequest{Parent: m.GetName()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var bodies []string
+	for _, r := range resp.GetReplies() {
+		if r.GetParent() != m.GetName() || r.GetName() == "" {
+			t.Errorf("unexpected reply %v", r)
+		}
+		bodies = append(bodies, r.GetBody())
+	}
+	if fmt.Sprint(bodies) != "[Yes we do. In red?]" {
+		t.Errorf("expected thread in order, got %v", bodies)
+	}
+
+	if _, err := s.DeleteMessage(ctx, &pb.DeleteMessageRequest{Name: m.GetName()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replies, _ := s.getReplies(ctx, m.GetName()); len(replies) != 0 {
+		t.Errorf("expected replies to be deleted with the message, got %v", replies)
+	}
+	if replies, _ := s.getReplies(ctx, other.GetName()); len(replies) != 1 {
+		t.Errorf("expected other thread to remain, got %v", replies)
+	}
+}
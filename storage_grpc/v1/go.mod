@@ -7,6 +7,7 @@ require (
 	github.com/GoogleCloudPlatform/cloudsql-proxy v0.0.0-20190625211440-fa714b45b08a // indirect
 	github.com/HayoVanLoon/go-commons v0.0.0-20190504173556-7f543fcadd02
 	github.com/HayoVanLoon/protoworkflow-genproto v0.0.0-20190625192144-df35325a481d
+	github.com/HayoVanLoon/protoworkflow/commons v0.0.0
 	github.com/aclements/go-gg v0.0.0-20170323211221-abd1f791f5ee // indirect
 	github.com/aclements/go-moremath v0.0.0-20190506201756-286cc0be6f75 // indirect
 	github.com/ajstarks/deck v0.0.0-20190526003814-edf08d731d5a // indirect
@@ -88,3 +89,5 @@ require (
 	gopkg.in/yaml.v1 v1.0.0-20140924161607-9f9df34309c0 // indirect
 	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
+
+replace github.com/HayoVanLoon/protoworkflow/commons => ../../commons/v1
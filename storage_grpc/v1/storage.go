@@ -19,18 +19,27 @@ package main
 
 import (
 	"crypto/sha1"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/HayoVanLoon/go-commons/sorted"
 	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"github.com/HayoVanLoon/protoworkflow/commons/measure"
 	"github.com/golang/protobuf/ptypes/empty"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 	"log"
 	"net"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 )
 
 const (
@@ -90,7 +99,7 @@ func toPb(k dkey) *pb.Key {
 		ks := strings.Split(kv, kvSep)
 		ps = append(ps, &pb.Key_Part{Key: ks[0], Value: ks[1]})
 	}
-	return &pb.Key{Parts: ps}
+	return &pb.Key{Name: string(k), Parts: ps}
 }
 
 type item struct {
@@ -109,11 +118,10 @@ type server struct {
 }
 
 func newServer() *server {
-	dataMap := dataMap{
+	return &server{dataMap{
 		idxs:  make(map[string]map[string]sorted.StringSet),
 		items: make(map[dkey]item),
-	}
-	return &server{dataMap}
+	}}
 }
 
 func (s *server) getData(key dkey) ([]byte, string, bool) {
@@ -130,35 +138,43 @@ func (s *server) getKeys(query []keyVal) []string {
 	defer s.data.RUnlock()
 
 	var left []string
-	for _, queryKv := range query {
+	for i, queryKv := range query {
+		var right []string
 		if vs, ok := s.data.idxs[queryKv.k]; ok {
 			if ks, ok := vs[queryKv.v]; ok {
-				if left == nil {
-					left = ks.Slice()
-				} else {
-					i, j := 0, 0
-					right := ks.Slice()
-					var intersect []string
-					for ; i < len(left) && j < len(right); {
-						if left[i] > right[i] {
-							j += 1
-						} else if left[j] < right[i] {
-							i += 1
-						} else {
-							intersect = append(intersect, left[j])
-							i += 1
-							j += 1
-						}
-					}
-					left = intersect
-				}
+				right = ks.Slice()
 			}
 		}
+		if i == 0 {
+			left = right
+		} else {
+			left = intersect(left, right)
+		}
+		if len(left) == 0 {
+			return nil
+		}
 	}
 
 	return left
 }
 
+// Intersects two sorted slices
+func intersect(left, right []string) []string {
+	var result []string
+	for i, j := 0, 0; i < len(left) && j < len(right); {
+		if left[i] > right[j] {
+			j += 1
+		} else if left[i] < right[j] {
+			i += 1
+		} else {
+			result = append(result, left[i])
+			i += 1
+			j += 1
+		}
+	}
+	return result
+}
+
 func (s *server) putData(key dkey, idx []keyVal, d []byte) (dkey, error) {
 	s.data.Lock()
 	defer s.data.Unlock()
@@ -166,7 +182,7 @@ func (s *server) putData(key dkey, idx []keyVal, d []byte) (dkey, error) {
 	if _, ex := s.data.items[key]; ex {
 		m := fmt.Sprintf("already have message with key %s", key)
 		log.Print(m)
-		return "", fmt.Errorf(m)
+		return "", errors.New(m)
 	}
 
 	it := item{idx: idx, data: d}
@@ -246,9 +262,9 @@ func (s *server) mutateData(oldKey, newKey *pb.Key, oldEtag string, newData []by
 }
 
 func (s *server) CreateObject(_ context.Context, req *pb.CreateObjectRequest) (*pb.CreateObjectResponse, error) {
-	key, err := s.putData(toKey(req.GetKey()), toIdx(req.GetKey(), false), req.GetData())
-	if err != nil {
-		return nil, fmt.Errorf("could not store %s", key)
+	key := toKey(req.GetKey())
+	if _, err := s.putData(key, toIdx(req.GetKey(), false), req.GetData()); err != nil {
+		return nil, status.Errorf(codes.AlreadyExists, "could not store %s", key)
 	}
 	log.Printf("DEBUG: stored %s", key)
 	return &pb.CreateObjectResponse{Name: string(key), Etag:getEtag(req.GetData())}, nil
@@ -278,7 +294,7 @@ func (s *server) GetObject(_ context.Context, req *pb.GetObjectRequest) (*pb.Get
 		} else {
 			m := fmt.Sprintf("empty query")
 			log.Printf("%s: %v", m, *req)
-			return nil, fmt.Errorf(m)
+			return nil, errors.New(m)
 		}
 	}
 
@@ -324,6 +340,7 @@ func (s *server) GetStats(_ context.Context, req *pb.GetStatsRequest) (*pb.GetSt
 
 func main() {
 	var port = flag.String("port", defaultPort, "port to listen on")
+	var dialMeasuring = measure.RegisterFlags(defaultPort)
 	flag.Parse()
 
 	lis, err := net.Listen("tcp", ":"+*port)
@@ -331,12 +348,30 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer()
+	recorder, err := dialMeasuring()
+	if err != nil {
+		log.Fatalf("failed to connect to measuring: %v", err)
+	}
+
+	s := grpc.NewServer(recorder.ServerOptions()...)
 	pb.RegisterStorageServer(s, newServer())
+	healthpb.RegisterHealthServer(s, health.NewServer())
 
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Print("INFO: shutting down")
+		s.GracefulStop()
+	}()
+
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+	if err := recorder.Close(); err != nil {
+		log.Printf("WARN: error closing measuring: %v", err)
+	}
 }
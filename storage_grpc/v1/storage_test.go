@@ -2,6 +2,10 @@ package main
 
 import (
 	pb "github.com/HayoVanLoon/protoworkflow-genproto/bobsknobshop/storage/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -90,6 +94,20 @@ func TestServer_PostObject_GetObject(t *testing.T) {
 	*/
 }
 
+func TestServer_CreateObjectExists(t *testing.T) {
+	s := newServer()
+	_, _ = s.CreateObject(nil, createMessage1)
+
+	_, err := s.CreateObject(nil, createMessage1)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("expected %v, got %v", codes.AlreadyExists, err)
+	}
+	key := string(toKey(createMessage1.GetKey()))
+	if msg := status.Convert(err).Message(); !strings.Contains(msg, key) {
+		t.Errorf("expected message to contain %q, got %q", key, msg)
+	}
+}
+
 func TestServer_Indexing(t *testing.T) {
 	s := newServer()
 	_, _ = s.CreateObject(nil, createMessage1)
@@ -116,6 +134,21 @@ func TestServer_Indexing(t *testing.T) {
 			[]string{"post message 1", "post message 3"},
 			false,
 		},
+		{
+			createGetObjectQueryReq([]*pb.Key_Part{{Key: "colour", Value: "red"}, {Key: "shape", Value: "round"}}),
+			[]string{"post message 1"},
+			false,
+		},
+		{
+			createGetObjectQueryReq([]*pb.Key_Part{{Key: "colour", Value: "green"}, {Key: "shape", Value: "square"}}),
+			nil,
+			false,
+		},
+		{
+			createGetObjectQueryReq([]*pb.Key_Part{{Key: "colour", Value: "blue"}, {Key: "shape", Value: "round"}}),
+			nil,
+			false,
+		},
 		{
 			createGetObjectQueryReq([]*pb.Key_Part{}),
 			nil,
@@ -147,3 +180,20 @@ func TestServer_Indexing(t *testing.T) {
 		}
 	}
 }
+
+func TestIntersect(t *testing.T) {
+	cases := []struct {
+		left, right, expected []string
+	}{
+		{[]string{"a", "b", "c"}, []string{"b", "c"}, []string{"b", "c"}},
+		{[]string{"b", "c"}, []string{"a", "b", "c"}, []string{"b", "c"}},
+		{[]string{"a", "c", "e"}, []string{"b", "c", "d", "e"}, []string{"c", "e"}},
+		{[]string{"a"}, []string{"b"}, nil},
+		{nil, []string{"a"}, nil},
+	}
+	for i, c := range cases {
+		if actual := intersect(c.left, c.right); !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("case %v: expected %v, got %v", i, c.expected, actual)
+		}
+	}
+}